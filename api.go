@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Tonkpils/snag/vow"
+)
+
+// the states of a build in the status of the http api
+const (
+	stateIdle     = "idle"
+	stateRunning  = "running"
+	stateCanceled = "canceled"
+	statePassed   = "passed"
+	stateFailed   = "failed"
+)
+
+// apiServer lets other programs follow and start builds over http.
+// It reports the same events as the json output to its clients.
+type apiServer struct {
+	*jsonReporter
+
+	b      *Bob
+	ln     net.Listener
+	events *broadcaster
+
+	// statusMtx guards the status of the current build, index
+	// has the position of each of its steps in steps
+	statusMtx sync.Mutex
+	status    apiStatus
+	queued    []string
+	index     map[*vow.Step]int
+}
+
+// apiStatus is what GET /status responds with, passed is
+// the result of the last build that finished, if any did
+type apiStatus struct {
+	Build    int           `json:"build"`
+	State    string        `json:"state"`
+	Passed   *bool         `json:"passed"`
+	Start    *time.Time    `json:"start,omitempty"`
+	Duration float64       `json:"duration,omitempty"`
+	Trigger  []string      `json:"trigger,omitempty"`
+	Steps    []historyStep `json:"steps"`
+}
+
+func newAPIServer(b *Bob) *apiServer {
+	events := newBroadcaster()
+	return &apiServer{
+		jsonReporter: newJSONReporter(events),
+		b:            b,
+		events:       events,
+		status:       apiStatus{Build: b.build, State: stateIdle, Steps: []historyStep{}},
+		index:        make(map[*vow.Step]int),
+	}
+}
+
+// listenAPI starts serving the http api of b on addr
+func listenAPI(b *Bob, addr string) (*apiServer, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := newAPIServer(b)
+	s.ln = ln
+	go http.Serve(ln, s.handler())
+	return s, nil
+}
+
+// Close stops listening and ends the event streams
+func (s *apiServer) Close() error {
+	s.events.close()
+	if s.ln == nil {
+		return nil
+	}
+	return s.ln.Close()
+}
+
+func (s *apiServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.serveStatus)
+	mux.HandleFunc("/trigger", s.serveTrigger)
+	mux.HandleFunc("/events", s.serveEvents)
+	mux.HandleFunc("/builds/", s.serveOutput)
+	return mux
+}
+
+// allowMethod responds with an error when the request
+// doesn't use the method and reports whether it did
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
+
+func (s *apiServer) serveStatus(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, "GET") {
+		return
+	}
+
+	s.statusMtx.Lock()
+	out, err := json.Marshal(s.status)
+	s.statusMtx.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(out, '\n'))
+}
+
+// serveTrigger starts a build, even if snag is paused
+func (s *apiServer) serveTrigger(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, "POST") {
+		return
+	}
+
+	go s.b.execute()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// serveOutput responds to /builds/<n>/output with the
+// full output of a build in the history
+func (s *apiServer) serveOutput(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, "GET") {
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/builds/"), "/")
+	if len(parts) != 2 || parts[1] != "output" {
+		http.NotFound(w, r)
+		return
+	}
+	n, err := strconv.Atoi(parts[0])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	entries, err := readHistory(s.b.history)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	e, err := findBuild(entries, n)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	writeBuild(w, e)
+}
+
+// serveEvents streams the events of snag as server-sent events
+// until the client goes away or snag is closed
+func (s *apiServer) serveEvents(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, "GET") {
+		return
+	}
+
+	f, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	c := s.events.subscribe()
+	defer s.events.unsubscribe(c)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	f.Flush()
+
+	for {
+		select {
+		case line, ok := <-c:
+			if !ok {
+				return
+			}
+			fmt.Fprintf(w, "data: %s\n\n", bytes.TrimSpace(line))
+			f.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *apiServer) OnChange(path string) {
+	s.statusMtx.Lock()
+	s.queued = append(s.queued, s.b.relative(path))
+	s.statusMtx.Unlock()
+
+	s.jsonReporter.OnChange(path)
+}
+
+func (s *apiServer) OnBuild(warning string) {
+	s.statusMtx.Lock()
+	now := time.Now()
+	s.status = apiStatus{
+		Build:   s.status.Build + 1,
+		State:   stateRunning,
+		Passed:  s.status.Passed,
+		Start:   &now,
+		Trigger: s.queued,
+		Steps:   []historyStep{},
+	}
+	s.queued = nil
+	s.index = make(map[*vow.Step]int)
+	s.statusMtx.Unlock()
+
+	s.jsonReporter.OnBuild(warning)
+}
+
+func (s *apiServer) OnStart(step *vow.Step) {
+	s.updateStep(step)
+	s.jsonReporter.OnStart(step)
+}
+
+func (s *apiServer) OnFinish(step *vow.Step) {
+	s.updateStep(step)
+	s.jsonReporter.OnFinish(step)
+}
+
+// updateStep adds the step to the status or updates
+// it if the step is already there
+func (s *apiServer) updateStep(step *vow.Step) {
+	hs := newHistoryStep(step)
+	hs.Output = ""
+
+	s.statusMtx.Lock()
+	defer s.statusMtx.Unlock()
+
+	if i, ok := s.index[step]; ok {
+		s.status.Steps[i] = hs
+		return
+	}
+	s.index[step] = len(s.status.Steps)
+	s.status.Steps = append(s.status.Steps, hs)
+}
+
+func (s *apiServer) OnCancel() {
+	s.statusMtx.Lock()
+	s.status.State = stateCanceled
+	s.statusMtx.Unlock()
+
+	s.jsonReporter.OnCancel()
+}
+
+// OnResult records the result of the build, a rerun of the step
+// that failed doesn't change whether the last build passed
+func (s *apiServer) OnResult(res *vow.Result, full bool, avg *averages) {
+	s.statusMtx.Lock()
+	if full {
+		passed := res.Passed()
+		s.status.Passed = &passed
+	}
+	switch {
+	case s.status.Passed == nil:
+		s.status.State = stateIdle
+	case *s.status.Passed:
+		s.status.State = statePassed
+	default:
+		s.status.State = stateFailed
+	}
+	s.status.Duration = res.Duration().Seconds()
+
+	// the result has the steps that never started as well
+	s.status.Steps = make([]historyStep, len(res.Steps))
+	s.index = make(map[*vow.Step]int)
+	for i, step := range res.Steps {
+		s.status.Steps[i] = newHistoryStep(step)
+		s.status.Steps[i].Output = ""
+		s.index[step] = i
+	}
+	s.statusMtx.Unlock()
+
+	s.jsonReporter.OnResult(res, full, avg)
+}
+
+// broadcaster sends everything written to it to each of its
+// subscribers. A subscriber that can't keep up is dropped.
+type broadcaster struct {
+	mtx     sync.Mutex
+	clients map[chan []byte]struct{}
+	closed  bool
+}
+
+// broadcastBuffer is how many writes a subscriber can fall behind
+const broadcastBuffer = 256
+
+func newBroadcaster() *broadcaster {
+	return &broadcaster{clients: make(map[chan []byte]struct{})}
+}
+
+func (b *broadcaster) Write(p []byte) (int, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	for c := range b.clients {
+		select {
+		case c <- append([]byte(nil), p...):
+		default:
+			delete(b.clients, c)
+			close(c)
+		}
+	}
+	return len(p), nil
+}
+
+// subscribe returns a channel that receives every write,
+// it is closed once the broadcaster is closed
+func (b *broadcaster) subscribe() chan []byte {
+	c := make(chan []byte, broadcastBuffer)
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.closed {
+		close(c)
+		return c
+	}
+	b.clients[c] = struct{}{}
+	return c
+}
+
+func (b *broadcaster) unsubscribe(c chan []byte) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if _, ok := b.clients[c]; ok {
+		delete(b.clients, c)
+		close(c)
+	}
+}
+
+func (b *broadcaster) close() {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.closed = true
+	for c := range b.clients {
+		delete(b.clients, c)
+		close(c)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Tonkpils/snag/vow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serveAPI(s *apiServer, method, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.handler().ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	return w
+}
+
+func TestAPIServer_Status(t *testing.T) {
+	b, _ := newKeysBuilder(t, config{})
+	defer b.Close()
+	b.watchDir = "/foo"
+	s := newAPIServer(b)
+
+	var status apiStatus
+	w := serveAPI(s, "GET", "/status")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, stateIdle, status.State)
+	assert.Nil(t, status.Passed)
+
+	start := time.Now()
+	build := &vow.Step{Args: []string{"go", "build"}, Start: start}
+	test := &vow.Step{Args: []string{"go", "test"}}
+	s.OnChange("/foo/main.go")
+	s.OnBuild("")
+	s.OnStart(build)
+
+	w = serveAPI(s, "GET", "/status")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, 1, status.Build)
+	assert.Equal(t, stateRunning, status.State)
+	assert.Equal(t, []string{"main.go"}, status.Trigger)
+	assert.Equal(t, []historyStep{{Command: "go build", Status: "Running"}}, status.Steps)
+
+	build.End = start.Add(time.Second)
+	build.Err = errors.New("exit status 2")
+	build.ExitCode = 2
+	build.Output = []byte("main.go:1: syntax error\n")
+	s.OnFinish(build)
+	test.Skipped = true
+	s.OnResult(&vow.Result{Steps: []*vow.Step{build, test}, Start: start, End: build.End}, true, nil)
+
+	w = serveAPI(s, "GET", "/status")
+	status = apiStatus{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, stateFailed, status.State)
+	require.NotNil(t, status.Passed)
+	assert.False(t, *status.Passed)
+	assert.Equal(t, float64(1), status.Duration)
+	assert.Equal(t, []historyStep{
+		{Command: "go build", Status: "Failed", ExitCode: 2, Duration: 1},
+		{Command: "go test", Status: "Skipped"},
+	}, status.Steps)
+
+	// the last result is kept while the next build runs
+	s.OnBuild("")
+	w = serveAPI(s, "GET", "/status")
+	status = apiStatus{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, 2, status.Build)
+	assert.Equal(t, stateRunning, status.State)
+	require.NotNil(t, status.Passed)
+	assert.False(t, *status.Passed)
+	assert.Empty(t, status.Steps)
+
+	// the rerun of the step that failed passing doesn't make the build pass
+	s.OnResult(&vow.Result{Steps: []*vow.Step{{Args: []string{"go", "build"}}}}, false, nil)
+	w = serveAPI(s, "GET", "/status")
+	status = apiStatus{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, stateFailed, status.State)
+	require.NotNil(t, status.Passed)
+	assert.False(t, *status.Passed)
+
+	assert.Equal(t, http.StatusMethodNotAllowed, serveAPI(s, "POST", "/status").Code)
+}
+
+func TestAPIServer_Output(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "snag-api")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	b, _ := newKeysBuilder(t, config{})
+	defer b.Close()
+	b.history = filepath.Join(tmpDir, "history.jsonl")
+	require.NoError(t, appendHistory(b.history, historyEntry{
+		Build: 3,
+		Steps: []historyStep{{Command: "go test", Status: "Failed", ExitCode: 1, Output: "FAIL\n"}},
+	}))
+	s := newAPIServer(b)
+
+	w := serveAPI(s, "GET", "/builds/3/output")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Build #3 Failed")
+	assert.Contains(t, w.Body.String(), "FAIL\n")
+
+	w = serveAPI(s, "GET", "/builds/4/output")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "build #4 is not in the history\n", w.Body.String())
+
+	assert.Equal(t, http.StatusNotFound, serveAPI(s, "GET", "/builds/three/output").Code)
+	assert.Equal(t, http.StatusNotFound, serveAPI(s, "GET", "/builds/3").Code)
+	assert.Equal(t, http.StatusNotFound, serveAPI(s, "GET", "/foo").Code)
+}
+
+func TestAPIServer_Trigger(t *testing.T) {
+	b, r := newKeysBuilder(t, config{Build: stepsFrom([]string{"echo hello"})})
+	defer b.Close()
+	s := newAPIServer(b)
+	b.reporter = teeReporter{reporter: r, others: []reporter{s}}
+
+	assert.Equal(t, http.StatusMethodNotAllowed, serveAPI(s, "GET", "/trigger").Code)
+	assert.Equal(t, 0, r.buildCount())
+
+	assert.Equal(t, http.StatusAccepted, serveAPI(s, "POST", "/trigger").Code)
+	res := <-r.results
+	assert.True(t, res.Passed())
+
+	var status apiStatus
+	require.NoError(t, json.Unmarshal(serveAPI(s, "GET", "/status").Body.Bytes(), &status))
+	assert.Equal(t, statePassed, status.State)
+}
+
+func TestAPIServer_Events(t *testing.T) {
+	b, _ := newKeysBuilder(t, config{})
+	defer b.Close()
+	s := newAPIServer(b)
+
+	srv := httptest.NewServer(s.handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/events")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	s.OnBuild("")
+	s.OnMessage("Paused, press p to resume\n")
+	require.NoError(t, s.Close())
+
+	var data []string
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		if line := sc.Text(); line != "" {
+			data = append(data, line)
+		}
+	}
+	require.Len(t, data, 2)
+
+	var e event
+	require.True(t, strings.HasPrefix(data[0], "data: "))
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(data[0], "data: ")), &e))
+	assert.Equal(t, "build_started", e.Event)
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(data[1], "data: ")), &e))
+	assert.Equal(t, "message", e.Event)
+	assert.Equal(t, "Paused, press p to resume", e.Message)
+}
+
+func TestBroadcaster(t *testing.T) {
+	b := newBroadcaster()
+	slow := b.subscribe()
+	fast := b.subscribe()
+
+	for i := 0; i <= broadcastBuffer; i++ {
+		b.Write([]byte("line\n"))
+		<-fast
+	}
+
+	// the subscriber that fell behind is dropped
+	n := 0
+	for range slow {
+		n++
+	}
+	assert.Equal(t, broadcastBuffer, n)
+
+	b.unsubscribe(fast)
+	b.unsubscribe(slow)
+	b.close()
+	_, ok := <-b.subscribe()
+	assert.False(t, ok, "subscribed after being closed")
+}
+
+func TestNewBuilder_HTTP(t *testing.T) {
+	b, err := NewBuilder(config{HTTP: "127.0.0.1:0"})
+	require.NoError(t, err)
+
+	tee, ok := b.reporter.(teeReporter)
+	require.True(t, ok, "the api is not reported to")
+	require.Len(t, tee.others, 1)
+	s := tee.others[0].(*apiServer)
+
+	resp, err := http.Get("http://" + s.ln.Addr().String() + "/status")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	require.NoError(t, b.Close())
+	_, err = net.Dial("tcp", s.ln.Addr().String())
+	assert.Error(t, err, "still listening")
+
+	_, err = NewBuilder(config{HTTP: "not an address"})
+	assert.Error(t, err)
+}
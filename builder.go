@@ -3,16 +3,18 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
-	"log"
+	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/Tonkpils/snag/vow"
-	"github.com/shiena/ansicolor"
 	fsn "gopkg.in/fsnotify.v1"
 )
 
@@ -22,19 +24,60 @@ var clearBuffer = func() {
 }
 
 type Bob struct {
-	w        *fsn.Watcher
-	mtx      sync.RWMutex
-	curVow   *vow.Vow
-	done     chan struct{}
-	watching map[string]struct{}
-	watchDir string
+	w         *fsn.Watcher
+	mtx       sync.RWMutex
+	curVow    *vow.Vow
+	startMtx  sync.Mutex
+	done      chan struct{}
+	closeOnce sync.Once
+	watching  map[string]struct{}
+	watchDir  string
 
 	depWarning   string
-	buildCmds    [][]string
-	runCmds      [][]string
+	buildCmds    []command
+	buildGraph   bool
+	failureCmds  []command
+	runCmds      []command
 	ignoredItems []string
 
-	verbose bool
+	stream   bool
+	tty      bool
+	timeout  time.Duration
+	reporter reporter
+
+	// build is the number of the last build, keepLogs
+	// is how many builds get to keep their logs
+	build    int
+	keepLogs int
+
+	// stateMtx guards what can be changed with the keys while
+	// snag is running, failed is the step that failed the last
+	// build and a build is pending if a change was made while paused.
+	// broken is set while the last build failed and finished is the
+	// number of the last build that finished since snag started.
+	// registered is what its steps registered, for the rerun of failed.
+	stateMtx   sync.Mutex
+	paused     bool
+	pending    bool
+	verbose    bool
+	failed     *command
+	broken     bool
+	finished   int
+	registered map[string]string
+
+	// timings are how long recent builds took
+	timings *timings
+
+	// hashes are the files the cached steps read during a build
+	hashes *fileHashes
+
+	// changes are the files that changed since the last build
+	// started and history is the file builds are recorded in
+	changes []string
+	history string
+
+	// hooks are the commands to run for each hook by its name
+	hooks map[string][][]string
 }
 
 func NewBuilder(c config) (*Bob, error) {
@@ -43,6 +86,7 @@ func NewBuilder(c config) (*Bob, error) {
 		return nil, err
 	}
 
+	registered := registeredNames(c.Build)
 	parseCmd := func(cmd string) (c []string) {
 		s := bufio.NewScanner(strings.NewReader(cmd))
 		s.Split(splitFunc)
@@ -52,31 +96,205 @@ func NewBuilder(c config) (*Bob, error) {
 
 		// check for environment variables inside script
 		if strings.Contains(cmd, "$$") {
-			replaceEnv(c)
+			replaceEnv(c, registered)
 		}
 		return c
 	}
 
-	buildCmds := make([][]string, len(c.Build))
+	r := newReporter(c, os.Stdout)
+	hashes := newFileHashes()
+
+	var newCommand func(s step) command
+	newCommand = func(s step) command {
+		c := command{
+			name:       s.Name,
+			limit:      s.Limit,
+			deps:       s.DependsOn,
+			retries:    s.Retries,
+			retryDelay: s.RetryDelay,
+
+			register:     s.Register,
+			allowFailure: s.AllowFailure,
+			always:       s.Always,
+			tty:          s.TTY,
+		}
+		if s.Ready != "" {
+			// the pattern was checked with the rest of the config
+			c.ready = regexp.MustCompile(s.Ready)
+		}
+		if len(s.Parallel) == 0 {
+			c.args = parseCmd(s.Cmd)
+			if len(s.Inputs) > 0 {
+				c.cache = newStepCache(s, hashes, r.OnError)
+			}
+			return c
+		}
+
+		c.parallel = make([]command, len(s.Parallel))
+		for i, p := range s.Parallel {
+			p.TTY = p.TTY || s.TTY
+			c.parallel[i] = newCommand(p)
+		}
+		return c
+	}
+
+	buildCmds := make([]command, len(c.Build))
 	for i, s := range c.Build {
-		buildCmds[i] = parseCmd(s)
+		buildCmds[i] = newCommand(s)
+	}
+
+	failureCmds := make([]command, len(c.OnFailure))
+	for i, s := range c.OnFailure {
+		failureCmds[i] = newCommand(s)
 	}
 
-	runCmds := make([][]string, len(c.Run))
+	runCmds := make([]command, len(c.Run))
 	for i, s := range c.Run {
-		runCmds[i] = parseCmd(s)
+		runCmds[i] = newCommand(s)
+	}
+
+	hookCmds := make(map[string][][]string)
+	for name, cmds := range c.Hooks.byName() {
+		for _, cmd := range cmds {
+			hookCmds[name] = append(hookCmds[name], parseCmd(cmd))
+		}
 	}
 
-	return &Bob{
+	b := &Bob{
 		w:            w,
 		done:         make(chan struct{}),
 		watching:     map[string]struct{}{},
 		buildCmds:    buildCmds,
+		buildGraph:   usesGraph(c.Build),
+		failureCmds:  failureCmds,
 		runCmds:      runCmds,
 		depWarning:   c.DepWarnning,
-		ignoredItems: c.IgnoredItems,
+		ignoredItems: append([]string{snagDir}, c.IgnoredItems...),
+		stream:       c.Stream,
+		tty:          c.TTY,
+		timeout:      c.Timeout,
+		reporter:     r,
+		build:        lastBuildNumber(),
+		keepLogs:     c.KeepLogs,
 		verbose:      c.Verbose,
-	}, nil
+		timings:      newTimings(),
+		hashes:       hashes,
+		history:      historyFile,
+		hooks:        hookCmds,
+	}
+
+	// the dashboard numbers builds like the logs and the history do
+	if u, ok := r.(*uiReporter); ok {
+		u.build = b.build
+	}
+
+	// the reporters that follow along with the one snag writes with,
+	// the ones already listening are closed if another can't listen
+	var others []reporter
+	fail := func(err error) (*Bob, error) {
+		for _, o := range others {
+			o.(io.Closer).Close()
+		}
+		w.Close()
+		return nil, err
+	}
+	if c.HTTP != "" {
+		s, err := listenAPI(b, c.HTTP)
+		if err != nil {
+			return fail(err)
+		}
+		others = append(others, s)
+	}
+	if c.LiveReload {
+		lr, err := listenLiveReload(liveReloadAddr, runCmds)
+		if err != nil {
+			return fail(err)
+		}
+		others = append(others, lr)
+	}
+	if c.Proxy.Listen != "" {
+		target, _ := proxyTarget(c.Proxy.Target)
+		p, err := listenProxy(c.Proxy.Listen, target, runCmds)
+		if err != nil {
+			return fail(err)
+		}
+		others = append(others, p)
+	}
+	if len(others) > 0 {
+		b.reporter = teeReporter{reporter: r, others: others}
+	}
+	return b, nil
+}
+
+// command is a step from the snag file that is ready to run
+type command struct {
+	name string
+	args []string
+
+	// parallel holds the commands to run at the same
+	// time instead, at most limit at once
+	parallel []command
+	limit    int
+
+	// deps names the commands that need to pass
+	// before this one when they form a graph
+	deps []string
+
+	// cache lets the command be skipped when its inputs
+	// have not changed since the last time it passed
+	cache *stepCache
+
+	retries    int
+	retryDelay time.Duration
+
+	register     string
+	allowFailure bool
+	always       bool
+	tty          bool
+
+	// ready matches the line a run command writes once it is ready
+	ready *regexp.Regexp
+}
+
+// label returns the name of the command or the command
+// itself, the same way its step is labeled once it runs
+func (c command) label() string {
+	if c.name != "" {
+		return c.name
+	}
+	return strings.Join(c.args, " ")
+}
+
+// then adds the command to the given vow
+func (c command) then(v *vow.Vow) *vow.Vow {
+	if len(c.parallel) == 0 {
+		v.Then(c.args[0], c.args[1:]...).As(c.name)
+		if c.cache != nil {
+			v.Cache(c.cache)
+		}
+		if c.retries > 0 {
+			v.Retry(c.retries, c.retryDelay)
+		}
+		if c.register != "" {
+			v.Register(c.register)
+		}
+		if c.allowFailure {
+			v.AllowFailure()
+		}
+		if c.always {
+			v.Always()
+		}
+		if c.tty {
+			v.Terminal()
+		}
+		return v
+	}
+
+	vows := make([]*vow.Vow, len(c.parallel))
+	for i, p := range c.parallel {
+		vows[i] = p.then(&vow.Vow{})
+	}
+	return v.ThenAll(vows...).Limit(c.limit)
 }
 
 func splitFunc(data []byte, atEOF bool) (advance int, token []byte, err error) {
@@ -112,18 +330,41 @@ func splitFunc(data []byte, atEOF bool) (advance int, token []byte, err error) {
 	return
 }
 
-func replaceEnv(cmds []string) {
+// replaceEnv replaces the arguments that start with $$ with the value of
+// the environment variable they name. Variables registered by a step are
+// left alone for the vow to fill in once the step has run.
+func replaceEnv(cmds []string, registered map[string]bool) {
 	for i, c := range cmds {
 		if !strings.HasPrefix(c, "$$") {
 			continue
 		}
 
-		cmds[i] = os.Getenv(strings.TrimPrefix(c, "$$"))
+		name := strings.TrimPrefix(c, "$$")
+		if registered[name] {
+			continue
+		}
+		cmds[i] = os.Getenv(name)
 	}
 }
 
+// Close stops watching and waits for every command
+// of the current build to exit before the on_exit hook
 func (b *Bob) Close() error {
-	close(b.done)
+	b.closeOnce.Do(func() {
+		close(b.done)
+		b.stopCurVow()
+
+		b.mtx.RLock()
+		n := b.build
+		b.mtx.RUnlock()
+		b.runHook(hookOnExit, hookEnv(buildRun{n: n}, nil))
+	})
+	b.stopCurVow()
+
+	// the reporter may need to clean up the screen
+	if c, ok := b.reporter.(io.Closer); ok {
+		c.Close()
+	}
 	return b.w.Close()
 }
 
@@ -132,6 +373,8 @@ func (b *Bob) Watch(path string) error {
 	// this can never return false since we will always
 	// have at least one file in the directory (.snag.yml)
 	_ = b.watch(path)
+	b.reporter.OnWatch(path)
+	b.runHook(hookOnStart, nil)
 	b.execute()
 
 	for {
@@ -154,7 +397,7 @@ func (b *Bob) Watch(path string) error {
 				b.maybeQueue(ev.Name)
 			}
 		case err := <-b.w.Errors:
-			log.Println("error:", err)
+			b.reporter.OnError(err)
 		case <-b.done:
 			return nil
 		}
@@ -171,7 +414,7 @@ func (b *Bob) maybeQueue(path string) {
 		// we couldn't find the file
 		// most likely a deletion
 		delete(mtimes, path)
-		b.execute()
+		b.queue(path)
 		return
 	}
 
@@ -181,47 +424,257 @@ func (b *Bob) maybeQueue(path string) {
 		// the file has been modified and the
 		// file system event wasn't bogus
 		mtimes[path] = mtime
+		b.queue(path)
+	}
+}
+
+// queue starts a build for the change to path unless snag
+// is paused, then the build waits until it is resumed
+func (b *Bob) queue(path string) {
+	b.reporter.OnChange(path)
+
+	b.stateMtx.Lock()
+	paused := b.paused
+	if paused {
+		b.pending = true
+	}
+	b.changes = append(b.changes, b.relative(path))
+	b.stateMtx.Unlock()
+
+	if !paused {
 		b.execute()
 	}
 }
 
+// stopCurVow stops the current build, b.mtx isn't held
+// while waiting for its commands to exit
 func (b *Bob) stopCurVow() {
-	b.mtx.Lock()
-	if b.curVow != nil {
-		b.curVow.Stop()
+	b.mtx.RLock()
+	v := b.curVow
+	b.mtx.RUnlock()
+
+	if v != nil {
+		v.Stop()
 	}
-	b.mtx.Unlock()
 }
 
 func (b *Bob) execute() {
-	b.stopCurVow()
+	b.startMtx.Lock()
+	defer b.startMtx.Unlock()
 
-	clearBuffer()
 	b.mtx.Lock()
+	run, prev := b.newVow()
 
-	if len(b.depWarning) > 0 {
-		fmt.Printf("Deprecation Warnings!\n%s", b.depWarning)
+	// setup the build commands
+	if b.buildGraph {
+		g := &vow.Graph{}
+		for _, c := range b.buildCmds {
+			g.Add(c.name, c.then(&vow.Vow{}), c.deps...)
+		}
+		b.curVow.ThenGraph(g)
+	} else {
+		for _, c := range b.buildCmds {
+			c.then(b.curVow)
+		}
 	}
 
-	// setup the first command
-	firstCmd := b.buildCmds[0]
-	b.curVow = vow.To(firstCmd[0], firstCmd[1:]...)
+	// setup the commands that clean up after a failed build
+	for _, c := range b.failureCmds {
+		c.then(b.curVow).OnFailure()
+	}
 
-	// setup the remaining commands
-	for i := 1; i < len(b.buildCmds); i++ {
-		cmd := b.buildCmds[i]
-		b.curVow = b.curVow.Then(cmd[0], cmd[1:]...)
+	// setup all the commands that keep running
+	for _, c := range b.runCmds {
+		b.curVow.ThenAsync(c.args[0], c.args[1:]...).As(c.name)
+		if c.tty {
+			b.curVow.Terminal()
+		}
 	}
 
-	// setup all parallel commands
-	for i := 0; i < len(b.runCmds); i++ {
-		cmd := b.runCmds[i]
-		b.curVow = b.curVow.ThenAsync(cmd[0], cmd[1:]...)
+	run.cmds = append(flatten(b.buildCmds), b.failureCmds...)
+	run.full = true
+	v := b.curVow
+	b.mtx.Unlock()
+
+	b.start(prev, v, run)
+}
+
+// rerunFailed starts a build of only the step
+// that failed the last build
+func (b *Bob) rerunFailed() {
+	b.stateMtx.Lock()
+	c, registered := b.failed, b.registered
+	b.stateMtx.Unlock()
+
+	if c == nil {
+		b.reporter.OnMessage("There is no failed step to rerun")
+		return
 	}
-	b.curVow.Verbose = b.verbose
-	go b.curVow.Exec(ansicolor.NewAnsiColorWriter(os.Stdout))
 
+	b.startMtx.Lock()
+	defer b.startMtx.Unlock()
+
+	b.mtx.Lock()
+	run, prev := b.newVow()
+	// the step can use what the steps before it registered
+	b.curVow.Vars = registered
+	c.then(b.curVow)
+	run.cmds = []command{*c}
+	v := b.curVow
 	b.mtx.Unlock()
+
+	b.start(prev, v, run)
+}
+
+// buildRun is what is known about a build as it starts
+type buildRun struct {
+	n       int
+	trigger []string
+
+	// cmds are the commands the steps of the build come from in the
+	// same order, full is set when they are the whole build rather
+	// than some of its steps
+	cmds []command
+	full bool
+}
+
+// newVow sets up the vow of the next build in place of the current
+// one, which it returns to be stopped by start. b.mtx must be held.
+func (b *Bob) newVow() (buildRun, *vow.Vow) {
+	b.stateMtx.Lock()
+	trigger := b.changes
+	b.changes = nil
+	b.stateMtx.Unlock()
+
+	prev := b.curVow
+	b.build++
+	b.curVow = &vow.Vow{Stream: b.stream, TTY: b.tty}
+	if b.keepLogs > 0 {
+		b.curVow.LogDir = filepath.Join(logsDir, strconv.Itoa(b.build))
+	}
+	return buildRun{n: b.build, trigger: trigger}, prev
+}
+
+// start stops prev, the vow of the last build, and executes v once the
+// before_build hook ran. b.mtx isn't held since both can take a while,
+// b.startMtx is so the builds start in order.
+func (b *Bob) start(prev, v *vow.Vow, run buildRun) {
+	if prev != nil {
+		prev.Stop()
+	}
+	b.reporter.OnBuild(b.depWarning)
+
+	b.hashes.reset()
+	if b.keepLogs > 0 {
+		if err := pruneLogs(logsDir, b.keepLogs-1); err != nil {
+			b.reporter.OnError(err)
+		}
+	}
+
+	b.runHook(hookBeforeBuild, hookEnv(run, nil))
+	b.exec(v, run)
+}
+
+// exec executes v, the vow of the run, in the background
+func (b *Bob) exec(v *vow.Vow, run buildRun) {
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if b.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, b.timeout)
+	}
+	go func() {
+		defer cancel()
+		res := v.ExecContext(ctx, b.reporter)
+		if res.Canceled {
+			return
+		}
+
+		recovered := b.finish(run, res)
+		if b.history != "" {
+			e := newHistoryEntry(run.n, run.trigger, res, !run.full)
+			if err := appendHistory(b.history, e); err != nil {
+				b.reporter.OnError(err)
+			}
+		}
+
+		avg := b.timings.averages()
+		b.timings.add(res, run.full)
+		b.report(run, res, avg)
+		b.runResultHooks(run, res, recovered)
+	}()
+}
+
+// report reports the result of the build of run unless the next build
+// has started already, b.startMtx is held so that it can't start while
+// the result is reported
+func (b *Bob) report(run buildRun, res *vow.Result, avg *averages) {
+	b.startMtx.Lock()
+	defer b.startMtx.Unlock()
+
+	b.mtx.RLock()
+	current := b.build == run.n
+	b.mtx.RUnlock()
+	if current {
+		b.reporter.OnResult(res, run.full, avg)
+	}
+}
+
+// finish records that the build of run finished with res, all at once
+// so the status is never told about half of it. A rerun of the failed
+// step doesn't change whether the build is broken. It reports whether
+// the build passed after one that failed.
+func (b *Bob) finish(run buildRun, res *vow.Result) bool {
+	b.stateMtx.Lock()
+	defer b.stateMtx.Unlock()
+
+	b.failed = failedCommand(res, run.cmds)
+	if run.n > b.finished {
+		b.finished = run.n
+	}
+	if !run.full {
+		return false
+	}
+
+	b.registered = res.Vars
+	recovered := b.broken && res.Passed()
+	b.broken = !res.Passed()
+	return recovered
+}
+
+// lastBuildNumber returns the number of the last build
+// that kept its logs or was recorded in the history
+func lastBuildNumber() int {
+	n := lastBuild(logsDir)
+	if h := lastHistoryBuild(historyFile); h > n {
+		n = h
+	}
+	return n
+}
+
+// failedCommand returns the command of the step
+// that failed the build, if there is one
+func failedCommand(res *vow.Result, cmds []command) *command {
+	failed := res.Failed()
+	for i, s := range res.Steps {
+		if s == failed && i < len(cmds) {
+			c := cmds[i]
+			return &c
+		}
+	}
+	return nil
+}
+
+// flatten returns the commands with the ones
+// of parallel blocks in place of the block
+func flatten(cmds []command) []command {
+	var flat []command
+	for _, c := range cmds {
+		if len(c.parallel) > 0 {
+			flat = append(flat, c.parallel...)
+		} else {
+			flat = append(flat, c)
+		}
+	}
+	return flat
 }
 
 func (b *Bob) watch(path string) bool {
@@ -253,9 +706,14 @@ func (b *Bob) watch(path string) bool {
 	return shouldBuild
 }
 
+// relative returns the path relative to the watched directory
+func (b *Bob) relative(path string) string {
+	return strings.TrimPrefix(path, b.watchDir+string(filepath.Separator))
+}
+
 func (b *Bob) isExcluded(path string) bool {
 	// get the relative path
-	path = strings.TrimPrefix(path, b.watchDir+string(filepath.Separator))
+	path = b.relative(path)
 
 	for _, p := range b.ignoredItems {
 		if globMatch(p, path) {
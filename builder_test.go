@@ -5,6 +5,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/Tonkpils/snag/vow"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -13,24 +14,28 @@ func TestNewBuilder(t *testing.T) {
 	testEnv := "foobar"
 	os.Setenv("TEST_ENV", testEnv)
 	c := config{
-		Build:        []string{"echo Hello World", "echo $$TEST_ENV"},
-		Run:          []string{"echo async here"},
+		Build:        stepsFrom([]string{"echo Hello World", "echo $$TEST_ENV"}),
+		Run:          []step{{Name: "async", Cmd: "echo async here"}},
 		IgnoredItems: []string{"foo", "bar"},
 		Verbose:      true,
+		Stream:       true,
 	}
 	b, err := NewBuilder(c)
 	assert.NoError(t, err)
 	assert.NotNil(t, b)
 
 	require.Len(t, b.buildCmds, 2)
-	assert.Equal(t, c.Build[0], strings.Join(b.buildCmds[0], " "))
-	assert.Equal(t, testEnv, b.buildCmds[1][1])
+	assert.Equal(t, c.Build[0].Cmd, strings.Join(b.buildCmds[0].args, " "))
+	assert.Equal(t, testEnv, b.buildCmds[1].args[1])
 
 	require.Len(t, b.runCmds, 1)
-	assert.Equal(t, c.Run[0], strings.Join(b.runCmds[0], " "))
+	assert.Equal(t, c.Run[0].Cmd, strings.Join(b.runCmds[0].args, " "))
+	assert.Equal(t, c.Run[0].Name, b.runCmds[0].name)
 
-	assert.Equal(t, c.Verbose, b.verbose)
-	assert.Equal(t, c.IgnoredItems, b.ignoredItems)
+	require.IsType(t, textReporter{}, b.reporter)
+	assert.Equal(t, c.Verbose, b.reporter.(textReporter).Verbose)
+	assert.Equal(t, c.Stream, b.stream)
+	assert.Equal(t, append([]string{snagDir}, c.IgnoredItems...), b.ignoredItems)
 }
 
 func TestNewBuilder_CmdWithQuotes(t *testing.T) {
@@ -70,18 +75,119 @@ func TestNewBuilder_CmdWithQuotes(t *testing.T) {
 
 	for _, test := range tests {
 		c := config{
-			Build: []string{test.Command},
-			Run:   []string{test.Command},
+			Build: []step{{Cmd: test.Command}},
+			Run:   []step{{Cmd: test.Command}},
 		}
 
 		b, err := NewBuilder(c)
 		require.NoError(t, err)
 
-		assert.Equal(t, test.Chunks, b.buildCmds[0])
-		assert.Equal(t, test.Chunks, b.runCmds[0])
+		assert.Equal(t, test.Chunks, b.buildCmds[0].args)
+		assert.Equal(t, test.Chunks, b.runCmds[0].args)
 	}
 }
 
+func TestNewBuilder_Parallel(t *testing.T) {
+	c := config{
+		Build: []step{
+			{Cmd: "go build"},
+			{
+				Parallel: []step{{Cmd: "go vet"}, {Name: "fmt", Cmd: "gofmt -l ."}},
+				Limit:    1,
+			},
+		},
+	}
+	b, err := NewBuilder(c)
+	require.NoError(t, err)
+	defer b.Close()
+
+	require.Len(t, b.buildCmds, 2)
+	g := b.buildCmds[1]
+	assert.Nil(t, g.args)
+	assert.Equal(t, 1, g.limit)
+	require.Len(t, g.parallel, 2)
+	assert.Equal(t, []string{"go", "vet"}, g.parallel[0].args)
+	assert.Equal(t, "fmt", g.parallel[1].name)
+	assert.Equal(t, []string{"gofmt", "-l", "."}, g.parallel[1].args)
+}
+
+func TestNewBuilder_DependsOn(t *testing.T) {
+	c := config{
+		Build: []step{
+			{Name: "build", Cmd: "go build"},
+			{Name: "test", Cmd: "go test", DependsOn: []string{"build"}},
+		},
+	}
+	b, err := NewBuilder(c)
+	require.NoError(t, err)
+	defer b.Close()
+
+	assert.True(t, b.buildGraph)
+	require.Len(t, b.buildCmds, 2)
+	assert.Equal(t, []string{"build"}, b.buildCmds[1].deps)
+}
+
+func TestNewBuilder_Cleanup(t *testing.T) {
+	c := config{
+		Build: []step{
+			{Cmd: "golint", AllowFailure: true},
+			{Cmd: "./teardown", Always: true},
+		},
+		OnFailure: []step{{Cmd: "./dump-logs"}},
+	}
+	b, err := NewBuilder(c)
+	require.NoError(t, err)
+	defer b.Close()
+
+	require.Len(t, b.buildCmds, 2)
+	assert.True(t, b.buildCmds[0].allowFailure)
+	assert.True(t, b.buildCmds[1].always)
+	require.Len(t, b.failureCmds, 1)
+	assert.Equal(t, []string{"./dump-logs"}, b.failureCmds[0].args)
+}
+
+func TestNewBuilder_Register(t *testing.T) {
+	os.Setenv("REV", "from env")
+	defer os.Unsetenv("REV")
+
+	c := config{
+		Build: []step{
+			{Cmd: "git rev-parse HEAD", Register: "REV"},
+			{Cmd: "echo $$REV $$HOME"},
+		},
+	}
+	b, err := NewBuilder(c)
+	require.NoError(t, err)
+	defer b.Close()
+
+	require.Len(t, b.buildCmds, 2)
+	assert.Equal(t, "REV", b.buildCmds[0].register)
+	assert.Equal(t, []string{"echo", "$$REV", os.Getenv("HOME")}, b.buildCmds[1].args)
+}
+
+func TestNewBuilder_TTY(t *testing.T) {
+	c := config{
+		TTY: true,
+		Build: []step{
+			{Cmd: "go build"},
+			{Parallel: []step{{Cmd: "go vet"}, {Cmd: "go test"}}, TTY: true},
+		},
+		Run: []step{{Cmd: "./server", TTY: true}},
+	}
+	b, err := NewBuilder(c)
+	require.NoError(t, err)
+	defer b.Close()
+
+	assert.True(t, b.tty)
+	require.Len(t, b.buildCmds, 2)
+	assert.False(t, b.buildCmds[0].tty)
+	require.Len(t, b.buildCmds[1].parallel, 2)
+	assert.True(t, b.buildCmds[1].parallel[0].tty)
+	assert.True(t, b.buildCmds[1].parallel[1].tty)
+	require.Len(t, b.runCmds, 1)
+	assert.True(t, b.runCmds[0].tty)
+}
+
 func TestClose(t *testing.T) {
 	b, err := NewBuilder(config{})
 	require.NoError(t, err)
@@ -92,3 +198,38 @@ func TestClose(t *testing.T) {
 	_, ok := <-b.done
 	assert.False(t, ok, "channel 'done' was not closed")
 }
+
+func TestNewBuilder_Ready(t *testing.T) {
+	c := config{
+		Build: []step{{Cmd: "go build -o server"}},
+		Run:   []step{{Cmd: "./server -port 8080", Ready: "listening"}, {Name: "assets", Cmd: "npm run watch"}},
+	}
+	b, err := NewBuilder(c)
+	require.NoError(t, err)
+	defer b.Close()
+
+	require.Len(t, b.runCmds, 2)
+	require.NotNil(t, b.runCmds[0].ready)
+	assert.Equal(t, "listening", b.runCmds[0].ready.String())
+	assert.Equal(t, "./server -port 8080", b.runCmds[0].label())
+	assert.Nil(t, b.runCmds[1].ready)
+	assert.Equal(t, "assets", b.runCmds[1].label())
+}
+
+func TestReport_Stale(t *testing.T) {
+	b, r := newKeysBuilder(t, config{})
+	defer b.Close()
+	b.build = 2
+
+	// the result of a build that was followed by another one is dropped
+	b.report(buildRun{n: 1}, &vow.Result{}, nil)
+	select {
+	case <-r.results:
+		t.Fatal("the result of build #1 was reported during build #2")
+	default:
+	}
+
+	res := &vow.Result{}
+	b.report(buildRun{n: 2}, res, nil)
+	assert.Equal(t, res, <-r.results)
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheDir is where the hashes of steps that passed are kept
+var cacheDir = filepath.Join(snagDir, "cache")
+
+// stepCache is the vow.Cache of a step with inputs. The step is
+// valid as long as its inputs and outputs hash to the same thing
+// they did the last time it passed.
+type stepCache struct {
+	file    string
+	inputs  []string
+	outputs []string
+	hashes  *fileHashes
+	onError func(error)
+
+	// sum is the hash of the inputs when the step was last checked,
+	// that is what the step was built from when it passes
+	sum string
+}
+
+// cacheRecord is what is kept on disk for a step that passed
+type cacheRecord struct {
+	Inputs  string `json:"inputs"`
+	Outputs string `json:"outputs,omitempty"`
+}
+
+func newStepCache(s step, hashes *fileHashes, onError func(error)) *stepCache {
+	// anything that changes what the step does gets a new cache
+	h := sha256.New()
+	io.WriteString(h, s.Cmd+"\x00")
+	io.WriteString(h, strings.Join(s.Inputs, "\x00")+"\x00")
+	io.WriteString(h, strings.Join(s.Outputs, "\x00"))
+
+	return &stepCache{
+		file:    filepath.Join(cacheDir, hex.EncodeToString(h.Sum(nil))+".json"),
+		inputs:  s.Inputs,
+		outputs: s.Outputs,
+		hashes:  hashes,
+		onError: onError,
+	}
+}
+
+func (c *stepCache) Valid() bool {
+	sum, err := c.hashes.hash(c.inputs)
+	if err != nil {
+		c.onError(err)
+		c.sum = ""
+		return false
+	}
+	c.sum = sum
+
+	in, err := ioutil.ReadFile(c.file)
+	if err != nil {
+		// the step has never passed
+		return false
+	}
+
+	var rec cacheRecord
+	if err := json.Unmarshal(in, &rec); err != nil || rec.Inputs != sum {
+		return false
+	}
+
+	if len(c.outputs) == 0 {
+		return true
+	}
+	outputs, err := c.hashes.hash(c.outputs)
+	return err == nil && outputs == rec.Outputs
+}
+
+func (c *stepCache) Save() {
+	if c.sum == "" {
+		return
+	}
+
+	rec := cacheRecord{Inputs: c.sum}
+	if len(c.outputs) > 0 {
+		sum, err := c.hashes.hash(c.outputs)
+		if err != nil {
+			c.onError(err)
+			return
+		}
+		rec.Outputs = sum
+	}
+
+	b, err := json.Marshal(rec)
+	if err != nil {
+		c.onError(err)
+		return
+	}
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		c.onError(err)
+		return
+	}
+	if err := ioutil.WriteFile(c.file, b, 0644); err != nil {
+		c.onError(err)
+	}
+}
+
+// fileHashes keeps the hash of every file read during a build, so
+// steps sharing inputs don't read the same files again. A file is only
+// read again when it changed since, like an output of an earlier step.
+type fileHashes struct {
+	mtx    sync.Mutex
+	hashes map[string]fileHash
+}
+
+type fileHash struct {
+	size    int64
+	modTime time.Time
+	sum     []byte
+}
+
+func newFileHashes() *fileHashes {
+	return &fileHashes{hashes: make(map[string]fileHash)}
+}
+
+// reset forgets every file, a build is starting
+func (fh *fileHashes) reset() {
+	fh.mtx.Lock()
+	fh.hashes = make(map[string]fileHash)
+	fh.mtx.Unlock()
+}
+
+// file returns the hash of the content of the file at p
+func (fh *fileHashes) file(p string, fi os.FileInfo) ([]byte, error) {
+	fh.mtx.Lock()
+	known, ok := fh.hashes[p]
+	fh.mtx.Unlock()
+	if ok && known.size == fi.Size() && known.modTime.Equal(fi.ModTime()) {
+		return known.sum, nil
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	sum := h.Sum(nil)
+
+	fh.mtx.Lock()
+	fh.hashes[p] = fileHash{size: fi.Size(), modTime: fi.ModTime(), sum: sum}
+	fh.mtx.Unlock()
+	return sum, nil
+}
+
+// hash returns a hash of the path and content of every file in the
+// current directory matching any of the patterns. It is the hash of
+// nothing if no file matches.
+func (fh *fileHashes) hash(patterns []string) (string, error) {
+	h := sha256.New()
+	for _, root := range walkRoots(patterns) {
+		if _, err := os.Lstat(root); os.IsNotExist(err) {
+			continue
+		}
+
+		err := filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if fi.IsDir() {
+				if p == snagDir || p == ".git" {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			p = filepath.ToSlash(p)
+			if !matchAny(patterns, p) {
+				return nil
+			}
+
+			sum, err := fh.file(p, fi)
+			if err != nil {
+				return err
+			}
+			io.WriteString(h, p+"\x00")
+			h.Write(sum)
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// walkRoots returns the directories the files matching the patterns
+// can be in, the fixed part each pattern starts with. A directory
+// inside another one that is already walked is left out.
+func walkRoots(patterns []string) []string {
+	var roots []string
+	for _, p := range patterns {
+		parts := strings.Split(strings.TrimPrefix(p, "./"), "/")
+		// a pattern without a slash matches files in any directory
+		fixed := []string{"."}
+		for _, part := range parts[:len(parts)-1] {
+			if strings.ContainsAny(part, "*?[\\") {
+				break
+			}
+			fixed = append(fixed, part)
+		}
+		roots = append(roots, path.Join(fixed...))
+	}
+	sort.Strings(roots)
+
+	var walked []string
+	for _, r := range roots {
+		if !insideAny(walked, r) {
+			walked = append(walked, r)
+		}
+	}
+	return walked
+}
+
+// insideAny reports whether dir is one of the directories or inside one
+func insideAny(dirs []string, dir string) bool {
+	for _, d := range dirs {
+		if d == "." || d == dir || strings.HasPrefix(dir, d+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if matchPath(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPath reports whether the slash separated name matches pattern.
+// A "**" element matches any number of directories and a pattern
+// without a slash matches the base name of files in any directory.
+func matchPath(pattern, name string) bool {
+	pattern = strings.TrimPrefix(pattern, "./")
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(name))
+		return ok
+	}
+	return matchParts(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchParts(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == dblAsterisks {
+			for i := 0; i <= len(name); i++ {
+				if matchParts(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
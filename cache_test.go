@@ -0,0 +1,126 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		Pattern string
+		Name    string
+		Match   bool
+	}{
+		{"*.proto", "api.proto", true},
+		{"*.proto", "api/v1/api.proto", true},
+		{"*.proto", "api.go", false},
+		{"web/**", "web/index.js", true},
+		{"web/**", "web/src/app/main.js", true},
+		{"web/**", "server/web.go", false},
+		{"./web/*.js", "web/index.js", true},
+		{"web/*.js", "web/src/main.js", false},
+		{"**/*.go", "main.go", true},
+		{"**/*.go", "vow/to.go", true},
+		{"api/**/*.proto", "api/api.proto", true},
+		{"api/**/*.proto", "api/v1/api.proto", true},
+		{"api/**/*.proto", "web/api.proto", false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.Match, matchPath(test.Pattern, test.Name), "%s %s", test.Pattern, test.Name)
+	}
+}
+
+func TestStepCache(t *testing.T) {
+	wd, tmpDir := tmpDirectory(t)
+	defer os.RemoveAll(tmpDir)
+
+	chdir(t, tmpDir)
+	defer os.Chdir(wd)
+
+	require.NoError(t, os.Mkdir("api", 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join("api", "api.proto"), []byte("v1"), 0644))
+
+	var errs []error
+	s := step{Cmd: "protoc", Inputs: []string{"*.proto"}, Outputs: []string{"*.pb.go"}}
+	hashes := newFileHashes()
+	c := newStepCache(s, hashes, func(err error) { errs = append(errs, err) })
+
+	// never passed
+	assert.False(t, c.Valid())
+	require.NoError(t, ioutil.WriteFile(filepath.Join("api", "api.pb.go"), []byte("v1"), 0644))
+	c.Save()
+	assert.True(t, c.Valid())
+
+	// survives a restart
+	assert.True(t, newStepCache(s, newFileHashes(), nil).Valid())
+
+	// a different command does not share the cache
+	assert.False(t, newStepCache(step{Cmd: "protoc -v", Inputs: s.Inputs}, hashes, nil).Valid())
+
+	// outputs are changed
+	require.NoError(t, ioutil.WriteFile(filepath.Join("api", "api.pb.go"), []byte("edited"), 0644))
+	assert.False(t, c.Valid())
+	c.Save()
+	assert.True(t, c.Valid())
+
+	// inputs are changed
+	hashes.reset()
+	require.NoError(t, ioutil.WriteFile(filepath.Join("api", "api.proto"), []byte("v2"), 0644))
+	assert.False(t, c.Valid())
+
+	assert.Empty(t, errs)
+}
+
+func TestWalkRoots(t *testing.T) {
+	assert.Equal(t, []string{"."}, walkRoots([]string{"web/**", "*.proto"}))
+	assert.Equal(t, []string{"api", "web"}, walkRoots([]string{"web/**", "./api/v1/*.proto", "api/**/*.go", "web/src/*.js"}))
+	assert.Equal(t, []string{"a", "a-b"}, walkRoots([]string{"a-b/*", "a/c/*", "a/*"}))
+	assert.Equal(t, []string{"."}, walkRoots([]string{"**/*.go"}))
+}
+
+func TestFileHashes(t *testing.T) {
+	wd, tmpDir := tmpDirectory(t)
+	defer os.RemoveAll(tmpDir)
+
+	chdir(t, tmpDir)
+	defer os.Chdir(wd)
+
+	require.NoError(t, os.Mkdir("web", 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join("web", "app.js"), []byte("v1"), 0644))
+	require.NoError(t, ioutil.WriteFile("main.go", []byte("v1"), 0644))
+
+	hashes := newFileHashes()
+	sum, err := hashes.hash([]string{"web/*.js"})
+	require.NoError(t, err)
+	_, ok := hashes.hashes["web/app.js"]
+	assert.True(t, ok, "web/app.js was not hashed")
+	_, ok = hashes.hashes["main.go"]
+	assert.False(t, ok, "main.go was hashed")
+
+	// a file that looks the same is only read once per build
+	fi, err := os.Stat(filepath.Join("web", "app.js"))
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join("web", "app.js"), []byte("v2"), 0644))
+	require.NoError(t, os.Chtimes(filepath.Join("web", "app.js"), fi.ModTime(), fi.ModTime()))
+	same, err := hashes.hash([]string{"web/*.js"})
+	require.NoError(t, err)
+	assert.Equal(t, sum, same)
+
+	hashes.reset()
+	changed, err := hashes.hash([]string{"web/*.js"})
+	require.NoError(t, err)
+	assert.NotEqual(t, sum, changed)
+
+	// a directory that doesn't exist has no files
+	none, err := hashes.hash([]string{"docs/*.md"})
+	require.NoError(t, err)
+	empty, err := hashes.hash(nil)
+	require.NoError(t, err)
+	assert.Equal(t, empty, none)
+}
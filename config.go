@@ -4,24 +4,460 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"time"
 
+	"github.com/mattn/go-isatty"
 	"gopkg.in/yaml.v2"
 )
 
 type config struct {
 	DepWarnning  string
-	Script       []string `yaml:"script"`
-	Build        []string `yaml:"build"`
-	Run          []string `yaml:"run"`
-	IgnoredItems []string `yaml:"ignore"`
-	Verbose      bool     `yaml:"verbose"`
+	Script       []string      `yaml:"script"`
+	Build        []step        `yaml:"build"`
+	Run          []step        `yaml:"run"`
+	OnFailure    []step        `yaml:"on_failure"`
+	IgnoredItems []string      `yaml:"ignore"`
+	Verbose      bool          `yaml:"verbose"`
+	Stream       bool          `yaml:"stream"`
+	Timestamps   bool          `yaml:"timestamps"`
+	Timeout      time.Duration `yaml:"timeout"`
+	KeepLogs     int           `yaml:"keep_logs"`
+	TTY          bool          `yaml:"tty"`
+	NoClear      bool          `yaml:"no_clear"`
+	Hooks        hooks         `yaml:"hooks"`
+	HTTP         string        `yaml:"http"`
+	LiveReload   bool          `yaml:"livereload"`
+	Proxy        proxy         `yaml:"proxy"`
+	Output       string        `yaml:"-"`
+	UI           bool          `yaml:"-"`
+	Color        bool          `yaml:"-"`
+}
+
+// step is a single command in the snag file. It can either be
+// written as a plain command or as a map with a name and a command.
+// A step in the build section can instead hold a list of steps
+// to run in parallel or name the steps it depends on.
+type step struct {
+	Name string `yaml:"name"`
+	Cmd  string `yaml:"cmd"`
+
+	Parallel []step `yaml:"parallel"`
+	Limit    int    `yaml:"limit"`
+
+	DependsOn []string `yaml:"depends_on"`
+
+	// Inputs and Outputs are the files a step works with,
+	// the step is skipped when none of them have changed
+	Inputs  []string `yaml:"inputs"`
+	Outputs []string `yaml:"outputs"`
+
+	// Retries is how many more times a failing
+	// step is run, waiting RetryDelay in between
+	Retries    int           `yaml:"retries"`
+	RetryDelay time.Duration `yaml:"retry_delay"`
+
+	// AllowFailure lets the build go on when the step fails
+	// and Always runs the step even if a step before it failed
+	AllowFailure bool `yaml:"allow_failure"`
+	Always       bool `yaml:"always"`
+
+	// Register is the variable the trimmed stdout
+	// of the step is saved in for the steps after it
+	Register string `yaml:"register"`
+
+	// TTY runs the step under a pseudo-terminal, for a
+	// parallel block it applies to each of its steps
+	TTY bool `yaml:"tty"`
+
+	// Ready matches the line a run step writes once it
+	// is ready to be used, like a server listening
+	Ready string `yaml:"ready"`
+}
+
+func (s *step) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&s.Cmd); err == nil {
+		return nil
+	}
+
+	// avoid recursing back into this method
+	type plain step
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+
+	if s.Cmd == "" && len(s.Parallel) == 0 {
+		return errors.New("every step needs a 'cmd'")
+	}
+	return nil
+}
+
+// hooks are the commands run when something happens to snag or
+// to a build, they are not steps and never change its result
+type hooks struct {
+	BeforeBuild commands `yaml:"before_build"`
+	AfterBuild  commands `yaml:"after_build"`
+	OnSuccess   commands `yaml:"on_success"`
+	OnFailure   commands `yaml:"on_failure"`
+	OnRecover   commands `yaml:"on_recover"`
+	OnStart     commands `yaml:"on_start"`
+	OnExit      commands `yaml:"on_exit"`
+}
+
+// byName returns the commands of each hook by its name in the snag file
+func (h hooks) byName() map[string]commands {
+	return map[string]commands{
+		hookBeforeBuild: h.BeforeBuild,
+		hookAfterBuild:  h.AfterBuild,
+		hookOnSuccess:   h.OnSuccess,
+		hookOnFailure:   h.OnFailure,
+		hookOnRecover:   h.OnRecover,
+		hookOnStart:     h.OnStart,
+		hookOnExit:      h.OnExit,
+	}
+}
+
+// proxy puts snag in front of the app started by the run
+// commands, it listens on Listen and forwards to Target
+type proxy struct {
+	Listen string `yaml:"listen"`
+	Target string `yaml:"target"`
+}
+
+// commands is a list of commands that can
+// also be written as a single command
+type commands []string
+
+func (c *commands) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var cmd string
+	if err := unmarshal(&cmd); err == nil {
+		*c = commands{cmd}
+		return nil
+	}
+	return unmarshal((*[]string)(c))
+}
+
+// stepsFrom turns a list of commands into steps
+func stepsFrom(cmds []string) []step {
+	steps := make([]step, len(cmds))
+	for i, c := range cmds {
+		steps[i] = step{Cmd: c}
+	}
+	return steps
+}
+
+// label returns the name of the step or its command if it has none
+func (s step) label() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return s.Cmd
+}
+
+// usesGraph reports whether the steps form a dependency
+// graph instead of a list that is run in order
+func usesGraph(steps []step) bool {
+	for _, s := range steps {
+		if len(s.DependsOn) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// validateSteps makes sure the steps are only using
+// 'parallel' and 'depends_on' where they are allowed to
+func validateSteps(c config) error {
+	if err := validateOutsideBuild(c.Run); err != nil {
+		return err
+	}
+
+	if err := validateOutsideBuild(c.OnFailure); err != nil {
+		return err
+	}
+
+	if err := validateReady(c); err != nil {
+		return err
+	}
+
+	if err := validateCache(c.Build); err != nil {
+		return err
+	}
+
+	if err := validateRetries(c.Build); err != nil {
+		return err
+	}
+
+	if err := validateRegister(c.Build); err != nil {
+		return err
+	}
+
+	if err := validateProxy(c.Proxy); err != nil {
+		return err
+	}
+
+	for name, cmds := range c.Hooks.byName() {
+		for _, cmd := range cmds {
+			if strings.TrimSpace(cmd) == "" {
+				return fmt.Errorf("hook %q has an empty command", name)
+			}
+		}
+	}
+
+	graph := usesGraph(c.Build)
+	for _, s := range c.Build {
+		if graph && s.Always {
+			return errors.New("'always' can't be used together with 'depends_on'")
+		}
+
+		if len(s.Parallel) == 0 {
+			continue
+		}
+
+		if graph {
+			return errors.New("'parallel' can't be used together with 'depends_on'")
+		}
+
+		if s.AllowFailure || s.Always {
+			return errors.New("a 'parallel' block can't use 'allow_failure' or 'always'")
+		}
+
+		if s.Cmd != "" {
+			return errors.New("a step can't have both a 'cmd' and 'parallel'")
+		}
+
+		for _, p := range s.Parallel {
+			if len(p.Parallel) > 0 {
+				return errors.New("'parallel' steps can't be nested")
+			}
+			if len(p.DependsOn) > 0 {
+				return errors.New("'depends_on' can't be used inside 'parallel'")
+			}
+		}
+	}
+
+	if graph {
+		return validateGraph(c.Build)
+	}
+	return nil
+}
+
+// validateOutsideBuild makes sure steps that are not in the
+// build section only use what applies to them
+func validateOutsideBuild(steps []step) error {
+	for _, s := range steps {
+		if len(s.Parallel) > 0 {
+			return errors.New("'parallel' can only be used in 'build'")
+		}
+		if len(s.DependsOn) > 0 {
+			return errors.New("'depends_on' can only be used in 'build'")
+		}
+		if len(s.Inputs) > 0 || len(s.Outputs) > 0 {
+			return errors.New("'inputs' and 'outputs' can only be used in 'build'")
+		}
+		if s.Retries != 0 {
+			return errors.New("'retries' can only be used in 'build'")
+		}
+		if s.AllowFailure || s.Always {
+			return errors.New("'allow_failure' and 'always' can only be used in 'build'")
+		}
+		if s.Register != "" {
+			return errors.New("'register' can only be used in 'build'")
+		}
+	}
+	return nil
+}
+
+// validateReady makes sure only run steps have a 'ready' pattern
+// and that the patterns can be used
+func validateReady(c config) error {
+	for _, s := range append(c.Build, c.OnFailure...) {
+		for _, p := range append([]step{s}, s.Parallel...) {
+			if p.Ready != "" {
+				return errors.New("'ready' can only be used in 'run'")
+			}
+		}
+	}
+
+	for _, s := range c.Run {
+		if s.Ready == "" {
+			continue
+		}
+		if _, err := regexp.Compile(s.Ready); err != nil {
+			return fmt.Errorf("step %q has an invalid 'ready' pattern: %s", s.label(), err)
+		}
+	}
+	return nil
+}
+
+// validateProxy makes sure the proxy knows where to listen and forward to
+func validateProxy(p proxy) error {
+	if p.Listen == "" && p.Target == "" {
+		return nil
+	}
+	if p.Listen == "" || p.Target == "" {
+		return errors.New("'proxy' needs both a 'listen' and a 'target'")
+	}
+	_, err := proxyTarget(p.Target)
+	return err
+}
+
+// varName matches the names steps can register
+var varName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validateRegister makes sure the variables steps register can be used
+func validateRegister(steps []step) error {
+	for _, s := range steps {
+		if len(s.Parallel) > 0 {
+			if s.Register != "" {
+				return errors.New("'register' goes on the steps inside 'parallel'")
+			}
+			if err := validateRegister(s.Parallel); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if s.Register == "" {
+			continue
+		}
+		if !varName.MatchString(s.Register) {
+			return fmt.Errorf("step %q can't register %q, it is not a valid variable name", s.label(), s.Register)
+		}
+		if len(s.Inputs) > 0 {
+			return fmt.Errorf("step %q can't have 'inputs' since its output is registered", s.label())
+		}
+	}
+	return nil
+}
+
+// registeredNames returns the names of the variables the steps register
+func registeredNames(steps []step) map[string]bool {
+	names := make(map[string]bool)
+	for _, s := range steps {
+		if s.Register != "" {
+			names[s.Register] = true
+		}
+		for n := range registeredNames(s.Parallel) {
+			names[n] = true
+		}
+	}
+	return names
+}
+
+// validateCache makes sure only steps with a
+// command and inputs have their outputs cached
+func validateCache(steps []step) error {
+	for _, s := range steps {
+		if len(s.Parallel) > 0 {
+			if len(s.Inputs) > 0 || len(s.Outputs) > 0 {
+				return errors.New("'inputs' and 'outputs' go on the steps inside 'parallel'")
+			}
+			if err := validateCache(s.Parallel); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if len(s.Outputs) > 0 && len(s.Inputs) == 0 {
+			return fmt.Errorf("step %q has 'outputs' but no 'inputs'", s.label())
+		}
+	}
+	return nil
+}
+
+// validateRetries makes sure steps are retried a sensible amount of times
+func validateRetries(steps []step) error {
+	for _, s := range steps {
+		if len(s.Parallel) > 0 {
+			if s.Retries != 0 {
+				return errors.New("'retries' go on the steps inside 'parallel'")
+			}
+			if err := validateRetries(s.Parallel); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if s.Retries < 0 {
+			return fmt.Errorf("step %q can't have negative 'retries'", s.label())
+		}
+	}
+	return nil
+}
+
+// validateGraph makes sure every dependency of the steps
+// exists and that none of them depend on each other in a cycle
+func validateGraph(steps []step) error {
+	byName := make(map[string]step)
+	for _, s := range steps {
+		if s.Name == "" {
+			continue
+		}
+		if _, ok := byName[s.Name]; ok {
+			return fmt.Errorf("step name %q is used more than once", s.Name)
+		}
+		byName[s.Name] = s
+	}
+
+	for _, s := range steps {
+		for _, d := range s.DependsOn {
+			if _, ok := byName[d]; !ok {
+				return fmt.Errorf("step %q depends on unknown step %q", s.label(), d)
+			}
+		}
+	}
+
+	const (
+		visiting = iota + 1
+		visited
+	)
+	state := make(map[string]int)
+	var path []string
+	var visit func(s step) error
+	visit = func(s step) error {
+		path = append(path, s.Name)
+		defer func() { path = path[:len(path)-1] }()
+
+		state[s.Name] = visiting
+		for _, d := range s.DependsOn {
+			switch state[d] {
+			case visiting:
+				for i, p := range path {
+					if p == d {
+						return fmt.Errorf("steps depend on each other in a cycle: %s -> %s", strings.Join(path[i:], " -> "), d)
+					}
+				}
+			case visited:
+				continue
+			}
+
+			if err := visit(byName[d]); err != nil {
+				return err
+			}
+		}
+		state[s.Name] = visited
+		return nil
+	}
+
+	for _, s := range steps {
+		if s.Name == "" || state[s.Name] == visited {
+			continue
+		}
+		if err := visit(s); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func parseConfig() (config, error) {
 	var c config
 
 	// if we have any cliCmds, set them to our build phase
-	c.Build = cliCmds
+	c.Build = stepsFrom(cliCmds)
 
 	// if build phase is still empty try and find the snag.yml file
 	if len(c.Build) == 0 {
@@ -45,13 +481,67 @@ func parseConfig() (config, error) {
 	// and set whatever its contents are to build
 	if len(c.Script) != 0 {
 		c.DepWarnning += "*\tThe use of 'script' in the yaml file has been deprecated and will be removed in the future.\n\tPlease start using 'build' instead.\n\n"
-		c.Build = c.Script
+		c.Build = stepsFrom(c.Script)
 	}
 
 	if len(c.Build) == 0 {
 		return c, errors.New("you must specify at least 1 command.")
 	}
 
+	if err := validateSteps(c); err != nil {
+		return c, err
+	}
+
+	if c.KeepLogs < 0 {
+		return c, errors.New("'keep_logs' can't be negative")
+	}
+
 	c.Verbose = verbose || c.Verbose
+	c.Stream = stream || c.Stream
+	if httpAddr != "" {
+		c.HTTP = httpAddr
+	}
+
+	c.Output = output
+	if c.Output != outputText && c.Output != outputJSON {
+		return c, fmt.Errorf("unknown output %q, must be %q or %q", c.Output, outputText, outputJSON)
+	}
+
+	// the dashboard shows the output of steps as it comes in
+	c.UI = ui
+	if c.UI && c.Output == outputJSON {
+		return c, errors.New("the dashboard can't be used with json output")
+	}
+	c.Stream = c.Stream || c.UI
+
+	// escape codes only make sense in a terminal
+	tty := isatty.IsTerminal(os.Stdout.Fd())
+	c.NoClear = noClear || c.NoClear || !tty
+	color, err := useColor(colors, tty)
+	if err != nil {
+		return c, err
+	}
+	c.Color = color
 	return c, nil
 }
+
+const (
+	colorAuto   = "auto"
+	colorAlways = "always"
+	colorNever  = "never"
+)
+
+// useColor reports whether colors are used for the --color mode
+// when writing to a terminal, or not. Colors are left out in auto
+// mode when the NO_COLOR environment variable is set.
+func useColor(mode string, tty bool) (bool, error) {
+	switch mode {
+	case colorAlways:
+		return true, nil
+	case colorNever:
+		return false, nil
+	case colorAuto:
+		return tty && os.Getenv("NO_COLOR") == "", nil
+	}
+	return false, fmt.Errorf("unknown color %q, must be %q, %q or %q", mode, colorAuto, colorAlways, colorNever)
+}
@@ -4,6 +4,7 @@ import (
 	"os"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -16,7 +17,7 @@ func TestParseConfig_CliCmds(t *testing.T) {
 
 	c, err := parseConfig()
 	require.NoError(t, err)
-	assert.Equal(t, args, c.Build)
+	assert.Equal(t, []step{{Cmd: "foo"}, {Cmd: "bar"}}, c.Build)
 }
 
 func TestParseConfig_NoSnagFile(t *testing.T) {
@@ -68,7 +69,7 @@ func TestParseConfig_Script(t *testing.T) {
 	writeSnagFile(t, "verbose: true\nscript:\n  - echo 'hello'")
 	c, err := parseConfig()
 	require.NoError(t, err)
-	assert.Equal(t, []string{"echo 'hello'"}, c.Build)
+	assert.Equal(t, []step{{Cmd: "echo 'hello'"}}, c.Build)
 }
 
 func TestParseConfig_EmptyBuild(t *testing.T) {
@@ -99,3 +100,489 @@ func TestParseConfig_Verbose(t *testing.T) {
 	require.NoError(t, err)
 	assert.True(t, c.Verbose, "verbosity was not set correctly")
 }
+
+func TestParseConfig_RunSteps(t *testing.T) {
+	wd, tmpDir := tmpDirectory(t)
+	defer os.RemoveAll(tmpDir)
+
+	chdir(t, tmpDir)
+	defer os.Chdir(wd)
+
+	writeSnagFile(t, "build:\n  - echo 'hello'\nrun:\n  - echo 'plain'\n  - name: server\n    cmd: echo 'named'")
+	c, err := parseConfig()
+	require.NoError(t, err)
+	assert.Equal(t, []step{
+		{Cmd: "echo 'plain'"},
+		{Name: "server", Cmd: "echo 'named'"},
+	}, c.Run)
+}
+
+func TestParseConfig_RunStepWithoutCmd(t *testing.T) {
+	wd, tmpDir := tmpDirectory(t)
+	defer os.RemoveAll(tmpDir)
+
+	chdir(t, tmpDir)
+	defer os.Chdir(wd)
+
+	writeSnagFile(t, "build:\n  - echo 'hello'\nrun:\n  - name: server")
+	_, err := parseConfig()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "every step needs a 'cmd'")
+}
+
+func TestParseConfig_Stream(t *testing.T) {
+	stream = true
+	defer func() { stream = false }()
+
+	wd, tmpDir := tmpDirectory(t)
+	defer os.RemoveAll(tmpDir)
+
+	chdir(t, tmpDir)
+	defer os.Chdir(wd)
+
+	writeSnagFile(t, "build:\n  - echo 'hello'")
+	c, err := parseConfig()
+	require.NoError(t, err)
+	assert.True(t, c.Stream, "streaming was not set correctly")
+}
+
+func TestParseConfig_HTTP(t *testing.T) {
+	wd, tmpDir := tmpDirectory(t)
+	defer os.RemoveAll(tmpDir)
+
+	chdir(t, tmpDir)
+	defer os.Chdir(wd)
+
+	writeSnagFile(t, "http: localhost:7777\nbuild:\n  - echo 'hello'")
+	c, err := parseConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "localhost:7777", c.HTTP)
+
+	// the flag takes precedence
+	httpAddr = ":8000"
+	defer func() { httpAddr = "" }()
+	c, err = parseConfig()
+	require.NoError(t, err)
+	assert.Equal(t, ":8000", c.HTTP)
+}
+
+func TestParseConfig_UI(t *testing.T) {
+	ui = true
+	defer func() { ui = false }()
+
+	args := []string{"go test"}
+	cliCmds = argSlice(args)
+	defer func() { cliCmds = nil }()
+
+	c, err := parseConfig()
+	require.NoError(t, err)
+	assert.True(t, c.UI)
+	assert.True(t, c.Stream, "the dashboard needs the output as it is written")
+
+	output = outputJSON
+	defer func() { output = outputText }()
+	_, err = parseConfig()
+	assert.EqualError(t, err, "the dashboard can't be used with json output")
+}
+
+func TestParseConfig_Color(t *testing.T) {
+	args := []string{"go test"}
+	cliCmds = argSlice(args)
+	defer func() { cliCmds = nil }()
+
+	// the tests don't write to a terminal
+	c, err := parseConfig()
+	require.NoError(t, err)
+	assert.False(t, c.Color)
+	assert.True(t, c.NoClear)
+
+	colors = colorAlways
+	defer func() { colors = colorAuto }()
+	c, err = parseConfig()
+	require.NoError(t, err)
+	assert.True(t, c.Color)
+
+	colors = "sometimes"
+	_, err = parseConfig()
+	assert.EqualError(t, err, `unknown color "sometimes", must be "auto", "always" or "never"`)
+}
+
+func TestUseColor(t *testing.T) {
+	os.Unsetenv("NO_COLOR")
+	tests := []struct {
+		Mode    string
+		TTY     bool
+		NoColor string
+		Color   bool
+	}{
+		{Mode: colorAuto, TTY: true, Color: true},
+		{Mode: colorAuto, TTY: false, Color: false},
+		{Mode: colorAuto, TTY: true, NoColor: "1", Color: false},
+		{Mode: colorAlways, TTY: false, NoColor: "1", Color: true},
+		{Mode: colorNever, TTY: true, Color: false},
+	}
+	for _, test := range tests {
+		os.Setenv("NO_COLOR", test.NoColor)
+		color, err := useColor(test.Mode, test.TTY)
+		require.NoError(t, err)
+		assert.Equal(t, test.Color, color, "%+v", test)
+	}
+	os.Unsetenv("NO_COLOR")
+}
+
+func TestParseConfig_UnknownOutput(t *testing.T) {
+	output = "xml"
+	defer func() { output = outputText }()
+
+	args := []string{"foo"}
+	cliCmds = argSlice(args)
+	defer func() { cliCmds = nil }()
+
+	_, err := parseConfig()
+	require.Error(t, err)
+	assert.Equal(t, `unknown output "xml", must be "text" or "json"`, err.Error())
+}
+
+func TestParseConfig_Timeout(t *testing.T) {
+	wd, tmpDir := tmpDirectory(t)
+	defer os.RemoveAll(tmpDir)
+
+	chdir(t, tmpDir)
+	defer os.Chdir(wd)
+
+	writeSnagFile(t, "timeout: 2m30s\nbuild:\n  - echo 'hello'")
+	c, err := parseConfig()
+	require.NoError(t, err)
+	assert.Equal(t, 150*time.Second, c.Timeout)
+}
+
+func TestParseConfig_Parallel(t *testing.T) {
+	wd, tmpDir := tmpDirectory(t)
+	defer os.RemoveAll(tmpDir)
+
+	chdir(t, tmpDir)
+	defer os.Chdir(wd)
+
+	writeSnagFile(t, `build:
+  - go build
+  - parallel:
+      - go vet
+      - name: fmt
+        cmd: gofmt -l .
+    limit: 1
+  - go test`)
+	c, err := parseConfig()
+	require.NoError(t, err)
+	assert.Equal(t, []step{
+		{Cmd: "go build"},
+		{
+			Parallel: []step{{Cmd: "go vet"}, {Name: "fmt", Cmd: "gofmt -l ."}},
+			Limit:    1,
+		},
+		{Cmd: "go test"},
+	}, c.Build)
+}
+
+func TestParseConfig_InvalidParallel(t *testing.T) {
+	tests := []struct {
+		Content string
+		Err     string
+	}{
+		{
+			Content: "build:\n  - cmd: go test\n    parallel:\n      - go vet",
+			Err:     "a step can't have both a 'cmd' and 'parallel'",
+		},
+		{
+			Content: "build:\n  - parallel:\n      - parallel:\n          - go vet",
+			Err:     "'parallel' steps can't be nested",
+		},
+		{
+			Content: "build:\n  - go test\nrun:\n  - parallel:\n      - ./server",
+			Err:     "'parallel' can only be used in 'build'",
+		},
+	}
+
+	wd, tmpDir := tmpDirectory(t)
+	defer os.RemoveAll(tmpDir)
+
+	chdir(t, tmpDir)
+	defer os.Chdir(wd)
+
+	for _, test := range tests {
+		writeSnagFile(t, test.Content)
+		_, err := parseConfig()
+		require.Error(t, err)
+		assert.Equal(t, test.Err, err.Error())
+	}
+}
+
+func TestParseConfig_DependsOn(t *testing.T) {
+	wd, tmpDir := tmpDirectory(t)
+	defer os.RemoveAll(tmpDir)
+
+	chdir(t, tmpDir)
+	defer os.Chdir(wd)
+
+	writeSnagFile(t, `build:
+  - name: build
+    cmd: go build
+  - name: test
+    cmd: go test
+    depends_on: [build]`)
+	c, err := parseConfig()
+	require.NoError(t, err)
+	assert.Equal(t, []step{
+		{Name: "build", Cmd: "go build"},
+		{Name: "test", Cmd: "go test", DependsOn: []string{"build"}},
+	}, c.Build)
+}
+
+func TestParseConfig_InvalidDependsOn(t *testing.T) {
+	tests := []struct {
+		Content string
+		Err     string
+	}{
+		{
+			Content: "build:\n  - name: test\n    cmd: go test\n    depends_on: [build]",
+			Err:     `step "test" depends on unknown step "build"`,
+		},
+		{
+			Content: "build:\n  - name: a\n    cmd: a\n    depends_on: [c]\n  - name: b\n    cmd: b\n    depends_on: [a]\n  - name: c\n    cmd: c\n    depends_on: [b]",
+			Err:     "steps depend on each other in a cycle: a -> c -> b -> a",
+		},
+		{
+			Content: "build:\n  - name: a\n    cmd: a\n    depends_on: [a]",
+			Err:     "steps depend on each other in a cycle: a -> a",
+		},
+		{
+			Content: "build:\n  - name: a\n    cmd: a\n  - name: a\n    cmd: b\n    depends_on: [a]",
+			Err:     `step name "a" is used more than once`,
+		},
+		{
+			Content: "build:\n  - name: a\n    cmd: a\n  - parallel: [b]\n    depends_on: [a]",
+			Err:     "'parallel' can't be used together with 'depends_on'",
+		},
+		{
+			Content: "build:\n  - go test\nrun:\n  - cmd: ./server\n    depends_on: [test]",
+			Err:     "'depends_on' can only be used in 'build'",
+		},
+	}
+
+	wd, tmpDir := tmpDirectory(t)
+	defer os.RemoveAll(tmpDir)
+
+	chdir(t, tmpDir)
+	defer os.Chdir(wd)
+
+	for _, test := range tests {
+		writeSnagFile(t, test.Content)
+		_, err := parseConfig()
+		require.Error(t, err)
+		assert.Equal(t, test.Err, err.Error())
+	}
+}
+
+func TestParseConfig_Retries(t *testing.T) {
+	wd, tmpDir := tmpDirectory(t)
+	defer os.RemoveAll(tmpDir)
+
+	chdir(t, tmpDir)
+	defer os.Chdir(wd)
+
+	writeSnagFile(t, `build:
+  - cmd: ./e2e
+    retries: 2
+    retry_delay: 500ms`)
+	c, err := parseConfig()
+	require.NoError(t, err)
+	assert.Equal(t, []step{{Cmd: "./e2e", Retries: 2, RetryDelay: 500 * time.Millisecond}}, c.Build)
+}
+
+func TestParseConfig_Cleanup(t *testing.T) {
+	wd, tmpDir := tmpDirectory(t)
+	defer os.RemoveAll(tmpDir)
+
+	chdir(t, tmpDir)
+	defer os.Chdir(wd)
+
+	writeSnagFile(t, `build:
+  - cmd: golint ./...
+    allow_failure: true
+  - go test ./...
+  - cmd: ./teardown-db
+    always: true
+on_failure:
+  - ./dump-logs`)
+	c, err := parseConfig()
+	require.NoError(t, err)
+	assert.Equal(t, []step{
+		{Cmd: "golint ./...", AllowFailure: true},
+		{Cmd: "go test ./..."},
+		{Cmd: "./teardown-db", Always: true},
+	}, c.Build)
+	assert.Equal(t, []step{{Cmd: "./dump-logs"}}, c.OnFailure)
+}
+
+func TestParseConfig_TTY(t *testing.T) {
+	wd, tmpDir := tmpDirectory(t)
+	defer os.RemoveAll(tmpDir)
+
+	chdir(t, tmpDir)
+	defer os.Chdir(wd)
+
+	writeSnagFile(t, `tty: true
+build:
+  - cmd: npm test
+    tty: true
+run:
+  - cmd: ./server
+    tty: true`)
+	c, err := parseConfig()
+	require.NoError(t, err)
+	assert.True(t, c.TTY)
+	assert.Equal(t, []step{{Cmd: "npm test", TTY: true}}, c.Build)
+	assert.Equal(t, []step{{Cmd: "./server", TTY: true}}, c.Run)
+}
+
+func TestParseConfig_Hooks(t *testing.T) {
+	wd, tmpDir := tmpDirectory(t)
+	defer os.RemoveAll(tmpDir)
+
+	chdir(t, tmpDir)
+	defer os.Chdir(wd)
+
+	writeSnagFile(t, `build:
+  - go test
+hooks:
+  before_build: ./rotate-logs
+  on_failure:
+    - notify-send failed
+    - paplay fail.oga`)
+	c, err := parseConfig()
+	require.NoError(t, err)
+	assert.Equal(t, hooks{
+		BeforeBuild: commands{"./rotate-logs"},
+		OnFailure:   commands{"notify-send failed", "paplay fail.oga"},
+	}, c.Hooks)
+}
+
+func TestParseConfig_LiveReload(t *testing.T) {
+	wd, tmpDir := tmpDirectory(t)
+	defer os.RemoveAll(tmpDir)
+
+	chdir(t, tmpDir)
+	defer os.Chdir(wd)
+
+	writeSnagFile(t, `livereload: true
+build:
+  - go build -o server
+run:
+  - cmd: ./server
+    ready: listening on`)
+	c, err := parseConfig()
+	require.NoError(t, err)
+	assert.True(t, c.LiveReload)
+	assert.Equal(t, []step{{Cmd: "./server", Ready: "listening on"}}, c.Run)
+}
+
+func TestParseConfig_Proxy(t *testing.T) {
+	wd, tmpDir := tmpDirectory(t)
+	defer os.RemoveAll(tmpDir)
+
+	chdir(t, tmpDir)
+	defer os.Chdir(wd)
+
+	writeSnagFile(t, `proxy: {listen: ':3000', target: ':3001'}
+build:
+  - go build -o server
+run:
+  - ./server`)
+	c, err := parseConfig()
+	require.NoError(t, err)
+	assert.Equal(t, proxy{Listen: ":3000", Target: ":3001"}, c.Proxy)
+}
+
+func TestParseConfig_InvalidStepOptions(t *testing.T) {
+	tests := []struct {
+		Content string
+		Err     string
+	}{
+		{
+			Content: "build:\n  - cmd: protoc\n    outputs: ['*.pb.go']",
+			Err:     `step "protoc" has 'outputs' but no 'inputs'`,
+		},
+		{
+			Content: "build:\n  - cmd: ./e2e\n    retries: -1",
+			Err:     `step "./e2e" can't have negative 'retries'`,
+		},
+		{
+			Content: "build:\n  - parallel: [go vet]\n    retries: 2",
+			Err:     "'retries' go on the steps inside 'parallel'",
+		},
+		{
+			Content: "build:\n  - cmd: git rev-parse HEAD\n    register: GIT-REV",
+			Err:     `step "git rev-parse HEAD" can't register "GIT-REV", it is not a valid variable name`,
+		},
+		{
+			Content: "build:\n  - cmd: ./port\n    register: PORT\n    inputs: ['*.go']",
+			Err:     `step "./port" can't have 'inputs' since its output is registered`,
+		},
+		{
+			Content: "build:\n  - go test\nrun:\n  - cmd: ./server\n    register: PID",
+			Err:     "'register' can only be used in 'build'",
+		},
+		{
+			Content: "build:\n  - parallel: [go vet]\n    allow_failure: true",
+			Err:     "a 'parallel' block can't use 'allow_failure' or 'always'",
+		},
+		{
+			Content: "build:\n  - name: a\n    cmd: a\n  - cmd: b\n    depends_on: [a]\n    always: true",
+			Err:     "'always' can't be used together with 'depends_on'",
+		},
+		{
+			Content: "build:\n  - go test\non_failure:\n  - cmd: ./teardown\n    retries: 1",
+			Err:     "'retries' can only be used in 'build'",
+		},
+		{
+			Content: "build:\n  - cmd: go test\n    ready: ok",
+			Err:     "'ready' can only be used in 'run'",
+		},
+		{
+			Content: "build:\n  - go build\nrun:\n  - cmd: ./server\n    ready: 'listening ('",
+			Err:     "step \"./server\" has an invalid 'ready' pattern: error parsing regexp: missing closing ): `listening (`",
+		},
+		{
+			Content: "build:\n  - go test\nhooks:\n  on_success: ['notify-send passed', '']",
+			Err:     `hook "on_success" has an empty command`,
+		},
+		{
+			Content: "build:\n  - go test\nproxy:\n  listen: ':3000'",
+			Err:     "'proxy' needs both a 'listen' and a 'target'",
+		},
+		{
+			Content: "build:\n  - go test\nproxy:\n  listen: ':3000'\n  target: 'http://'",
+			Err:     `invalid proxy target "http://"`,
+		},
+		{
+			Content: "build:\n  - parallel: [go vet]\n    inputs: ['*.go']",
+			Err:     "'inputs' and 'outputs' go on the steps inside 'parallel'",
+		},
+		{
+			Content: "build:\n  - go test\nrun:\n  - cmd: ./server\n    inputs: ['*.go']",
+			Err:     "'inputs' and 'outputs' can only be used in 'build'",
+		},
+	}
+
+	wd, tmpDir := tmpDirectory(t)
+	defer os.RemoveAll(tmpDir)
+
+	chdir(t, tmpDir)
+	defer os.Chdir(wd)
+
+	for _, test := range tests {
+		writeSnagFile(t, test.Content)
+		_, err := parseConfig()
+		require.Error(t, err)
+		assert.Equal(t, test.Err, err.Error())
+	}
+}
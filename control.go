@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// controlSocket is where a running snag listens for the
+// commands sent with snag trigger, pause, resume, status and stop
+var controlSocket = filepath.Join(snagDir, "snag.sock")
+
+// controlTimeout is how long a command and its reply can take
+const controlTimeout = 5 * time.Second
+
+var errNotRunning = errors.New("snag is not running in this directory")
+
+// controlReply is what snag answers a command with, failed is set
+// by status when the last build failed
+type controlReply struct {
+	Message string `json:"message,omitempty"`
+	Failed  bool   `json:"failed,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// controlServer lets other terminals and scripts control
+// snag with a command per connection to its socket
+type controlServer struct {
+	b  *Bob
+	ln net.Listener
+}
+
+// listenControl starts listening for commands to b on path. A socket
+// left behind by a snag that didn't exit cleanly is replaced.
+func listenControl(b *Bob, path string) (*controlServer, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, errors.New("snag is already running in this directory")
+		}
+		os.Remove(path)
+		if ln, err = net.Listen("unix", path); err != nil {
+			return nil, err
+		}
+	}
+
+	s := &controlServer{b: b, ln: ln}
+	go s.serve()
+	return s, nil
+}
+
+// Close stops listening and removes the socket
+func (s *controlServer) Close() error {
+	return s.ln.Close()
+}
+
+func (s *controlServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// serveConn reads a single command and answers it
+func (s *controlServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlTimeout))
+
+	cmd, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return
+	}
+	cmd = strings.TrimSpace(cmd)
+	json.NewEncoder(conn).Encode(s.handle(cmd))
+
+	// snag only stops once the reply is sent
+	if cmd == "stop" {
+		conn.Close()
+		s.b.Close()
+	}
+}
+
+func (s *controlServer) handle(cmd string) controlReply {
+	switch cmd {
+	case "trigger":
+		go s.b.execute()
+		return controlReply{Message: "Build started"}
+	case "pause":
+		if !s.b.setPaused(true) {
+			return controlReply{Message: "Already paused"}
+		}
+		return controlReply{Message: "Paused"}
+	case "resume":
+		if !s.b.setPaused(false) {
+			return controlReply{Message: "Not paused"}
+		}
+		return controlReply{Message: "Resumed"}
+	case "status":
+		msg, failed := s.b.status()
+		return controlReply{Message: msg, Failed: failed}
+	case "stop":
+		return controlReply{Message: "Stopping"}
+	default:
+		return controlReply{Error: fmt.Sprintf("unknown command %q", cmd)}
+	}
+}
+
+// status describes the builds and whether snag is paused,
+// it reports whether the last build that finished failed
+func (b *Bob) status() (string, bool) {
+	b.mtx.RLock()
+	build, started := b.build, b.curVow != nil
+	b.mtx.RUnlock()
+
+	b.stateMtx.Lock()
+	finished, broken, paused := b.finished, b.broken, b.paused
+	b.stateMtx.Unlock()
+
+	var parts []string
+	if started && build != finished {
+		parts = append(parts, fmt.Sprintf("build #%d is running", build))
+	}
+	switch {
+	case finished == 0 && len(parts) == 0:
+		parts = append(parts, "no build has finished yet")
+	case finished == 0:
+	case broken:
+		parts = append(parts, fmt.Sprintf("build #%d failed", finished))
+	default:
+		parts = append(parts, fmt.Sprintf("build #%d passed", finished))
+	}
+	if paused {
+		parts = append(parts, "paused")
+	}
+
+	msg := strings.Join(parts, ", ")
+	return strings.ToUpper(msg[:1]) + msg[1:], finished != 0 && broken
+}
+
+// sendControl sends the command to the snag listening on path
+func sendControl(path, cmd string) (controlReply, error) {
+	conn, err := net.DialTimeout("unix", path, controlTimeout)
+	if err != nil {
+		return controlReply{}, errNotRunning
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlTimeout))
+
+	if _, err := fmt.Fprintln(conn, cmd); err != nil {
+		return controlReply{}, err
+	}
+
+	var reply controlReply
+	if err := json.NewDecoder(conn).Decode(&reply); err != nil {
+		return controlReply{}, err
+	}
+	if reply.Error != "" {
+		return reply, errors.New(reply.Error)
+	}
+	return reply, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestControl(t *testing.T) {
+	_, tmpDir := tmpDirectory(t)
+	defer os.RemoveAll(tmpDir)
+	path := filepath.Join(tmpDir, snagDir, "snag.sock")
+
+	b, r := newKeysBuilder(t, config{Build: stepsFrom([]string{"false"})})
+	defer b.Close()
+	s, err := listenControl(b, path)
+	require.NoError(t, err)
+	defer s.Close()
+
+	reply, err := sendControl(path, "status")
+	require.NoError(t, err)
+	assert.Equal(t, controlReply{Message: "No build has finished yet"}, reply)
+
+	tests := []struct {
+		Cmd     string
+		Message string
+	}{
+		{"pause", "Paused"},
+		{"pause", "Already paused"},
+		{"status", "No build has finished yet, paused"},
+		{"resume", "Resumed"},
+		{"resume", "Not paused"},
+	}
+	for _, test := range tests {
+		reply, err := sendControl(path, test.Cmd)
+		require.NoError(t, err)
+		assert.Equal(t, test.Message, reply.Message, test.Cmd)
+	}
+
+	reply, err = sendControl(path, "trigger")
+	require.NoError(t, err)
+	assert.Equal(t, "Build started", reply.Message)
+	<-r.results
+
+	reply, err = sendControl(path, "status")
+	require.NoError(t, err)
+	assert.Equal(t, controlReply{Message: fmt.Sprintf("Build #%d failed", b.build), Failed: true}, reply)
+
+	_, err = sendControl(path, "rebuild")
+	assert.EqualError(t, err, `unknown command "rebuild"`)
+
+	reply, err = sendControl(path, "stop")
+	require.NoError(t, err)
+	assert.Equal(t, "Stopping", reply.Message)
+	select {
+	case <-b.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("snag was not stopped")
+	}
+}
+
+func TestStatus_Hooks(t *testing.T) {
+	tmpDir := hooksDir(t)
+	defer os.RemoveAll(tmpDir)
+	release := filepath.Join(tmpDir, "release")
+
+	b, r := newKeysBuilder(t, config{Build: stepsFrom([]string{"false"})})
+	defer b.Close()
+	b.hooks = map[string][][]string{
+		hookAfterBuild: {{"sh", "-c", "while [ ! -f " + release + " ]; do sleep 0.01; done; rm " + release}},
+	}
+
+	// the build is done while its hooks run
+	b.execute()
+	<-r.results
+	msg, failed := b.status()
+	assert.Equal(t, "Build #1 failed", msg)
+	assert.True(t, failed)
+
+	// the hook removes the file once it is done
+	require.NoError(t, ioutil.WriteFile(release, nil, 0644))
+	for i := 0; i < 500; i++ {
+		if _, err := os.Stat(release); os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("the after_build hook never returned")
+}
+
+func TestListenControl(t *testing.T) {
+	_, tmpDir := tmpDirectory(t)
+	defer os.RemoveAll(tmpDir)
+	path := filepath.Join(tmpDir, snagDir, "snag.sock")
+
+	b, _ := newKeysBuilder(t, config{})
+	defer b.Close()
+
+	_, err := sendControl(path, "status")
+	assert.Equal(t, errNotRunning, err)
+
+	// a socket left behind is replaced
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, ioutil.WriteFile(path, nil, 0644))
+	s, err := listenControl(b, path)
+	require.NoError(t, err)
+	defer s.Close()
+
+	_, err = listenControl(b, path)
+	assert.EqualError(t, err, "snag is already running in this directory")
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// explain writes the stages the build of c goes through. Every step
+// of a stage is run at the same time once the previous stage is done,
+// or as soon as the steps it depends on have passed in a graph.
+func explain(w io.Writer, c config) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	for i, stage := range stages(c.Build) {
+		fmt.Fprintf(tw, "Stage %d\n", i+1)
+		for _, s := range stage {
+			writeStep(tw, s)
+		}
+	}
+
+	if len(c.OnFailure) > 0 {
+		fmt.Fprintln(tw, "On failure")
+		for _, s := range c.OnFailure {
+			writeStep(tw, s)
+		}
+	}
+
+	if len(c.Run) > 0 {
+		fmt.Fprintln(tw, "Run")
+		for _, s := range c.Run {
+			writeStep(tw, s)
+		}
+	}
+	return tw.Flush()
+}
+
+func writeStep(w io.Writer, s step) {
+	fmt.Fprintf(w, "  %s", s.label())
+	if s.Name != "" {
+		fmt.Fprintf(w, "\t%s", s.Cmd)
+	}
+	if len(s.DependsOn) > 0 {
+		fmt.Fprintf(w, "\tafter %s", strings.Join(s.DependsOn, ", "))
+	}
+	fmt.Fprintln(w)
+}
+
+// stages groups the steps by the order they are run in
+func stages(steps []step) [][]step {
+	var stages [][]step
+	if !usesGraph(steps) {
+		for _, s := range steps {
+			if len(s.Parallel) > 0 {
+				stages = append(stages, s.Parallel)
+			} else {
+				stages = append(stages, []step{s})
+			}
+		}
+		return stages
+	}
+
+	// a step runs one stage after the latest of its dependencies
+	byName := make(map[string]step)
+	for _, s := range steps {
+		if s.Name != "" {
+			byName[s.Name] = s
+		}
+	}
+	depths := make(map[string]int)
+	var depth func(s step) int
+	depth = func(s step) int {
+		if d, ok := depths[s.Name]; ok && s.Name != "" {
+			return d
+		}
+
+		d := 0
+		for _, dep := range s.DependsOn {
+			if n := depth(byName[dep]) + 1; n > d {
+				d = n
+			}
+		}
+		if s.Name != "" {
+			depths[s.Name] = d
+		}
+		return d
+	}
+
+	for _, s := range steps {
+		d := depth(s)
+		for len(stages) <= d {
+			stages = append(stages, nil)
+		}
+		stages[d] = append(stages[d], s)
+	}
+	return stages
+}
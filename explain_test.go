@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplain(t *testing.T) {
+	c := config{
+		Build: []step{
+			{Cmd: "go build"},
+			{Parallel: []step{{Cmd: "go vet"}, {Name: "fmt", Cmd: "gofmt -l ."}}},
+		},
+		OnFailure: []step{{Cmd: "docker-compose down"}},
+		Run:       []step{{Name: "server", Cmd: "./server"}},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, explain(&buf, c))
+	assert.Equal(t, `Stage 1
+  go build
+Stage 2
+  go vet
+  fmt  gofmt -l .
+On failure
+  docker-compose down
+Run
+  server  ./server
+`, buf.String())
+}
+
+func TestExplainGraph(t *testing.T) {
+	c := config{
+		Build: []step{
+			{Name: "deploy", Cmd: "./deploy", DependsOn: []string{"vet", "test"}},
+			{Name: "build", Cmd: "go build"},
+			{Name: "vet", Cmd: "go vet", DependsOn: []string{"build"}},
+			{Name: "test", Cmd: "go test", DependsOn: []string{"build"}},
+			{Cmd: "gofmt -l ."},
+		},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, explain(&buf, c))
+	assert.Equal(t, `Stage 1
+  build  go build
+  gofmt -l .
+Stage 2
+  vet   go vet   after build
+  test  go test  after build
+Stage 3
+  deploy  ./deploy  after vet, test
+`, buf.String())
+}
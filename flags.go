@@ -7,14 +7,26 @@ import (
 )
 
 var (
-	cliCmds argSlice
-	version bool
-	verbose bool
+	cliCmds  argSlice
+	version  bool
+	verbose  bool
+	stream   bool
+	output   string
+	ui       bool
+	colors   string
+	noClear  bool
+	httpAddr string
 )
 
 func init() {
 	flag.Var(&cliCmds, "c", "List of commands to execute")
 	flag.BoolVar(&verbose, "v", false, "Verbose output")
+	flag.BoolVar(&stream, "s", false, "Stream the output of build commands as it is written")
+	flag.StringVar(&output, "output", outputText, "Output format, either 'text' or 'json'")
+	flag.BoolVar(&ui, "ui", false, "Show a full-screen dashboard instead of scrolling output")
+	flag.StringVar(&colors, "color", colorAuto, "When to use colors, 'auto', 'always' or 'never'")
+	flag.BoolVar(&noClear, "no-clear", false, "Print a separator between builds instead of clearing the screen")
+	flag.StringVar(&httpAddr, "http", "", "Serve the status of builds over http on this address, like ':7777'")
 	flag.BoolVar(&version, "version", false, "[DEPRECATED: use 'snag version'] display snag's version")
 
 	flag.Usage = func() {
@@ -24,6 +36,15 @@ func init() {
 Commands:
 
     init    	Generate a snag file %q used for configuration and execution
+    explain 	Show the order the build steps are run in
+    history 	List the last builds
+    show <n>	Show the output of build number n
+    last    	Show the output of the last build that failed
+    trigger 	Start a build in the snag running in this directory
+    pause   	Stop building on changes until resumed
+    resume  	Start building on changes again
+    status  	Show the last build, exits with 1 if it failed
+    stop    	Stop the snag running in this directory
     version 	Display snag's version
 
 Flags:
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Tonkpils/snag/vow"
+)
+
+// historyFile has a line of JSON for each of the last builds
+var historyFile = filepath.Join(snagDir, "history.jsonl")
+
+// historyLimit is how many builds are kept in the history
+const historyLimit = 100
+
+// historyEntry is what is remembered of a build
+type historyEntry struct {
+	Build    int       `json:"build"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Duration float64   `json:"duration"`
+	Passed   bool      `json:"passed"`
+	TimedOut bool      `json:"timed_out,omitempty"`
+
+	// Rerun is set when only the step that failed the
+	// build before was run again
+	Rerun bool `json:"rerun,omitempty"`
+
+	// Trigger has the files whose changes started the build,
+	// it is empty when the build was started some other way
+	Trigger []string `json:"trigger,omitempty"`
+
+	Steps []historyStep `json:"steps"`
+}
+
+// historyStep is what is remembered of a step. The output is only kept
+// for steps that failed, the rest of it can be found in the log file.
+type historyStep struct {
+	Name     string  `json:"name,omitempty"`
+	Command  string  `json:"command"`
+	Status   string  `json:"status"`
+	ExitCode int     `json:"exit_code"`
+	Duration float64 `json:"duration,omitempty"`
+	LogFile  string  `json:"log_file,omitempty"`
+	Output   string  `json:"output,omitempty"`
+}
+
+func newHistoryEntry(n int, trigger []string, res *vow.Result, rerun bool) historyEntry {
+	e := historyEntry{
+		Build:    n,
+		Start:    res.Start,
+		End:      res.End,
+		Duration: res.Duration().Seconds(),
+		Passed:   res.Passed(),
+		TimedOut: res.TimedOut(),
+		Rerun:    rerun,
+		Trigger:  trigger,
+	}
+	for _, s := range res.Steps {
+		e.Steps = append(e.Steps, newHistoryStep(s))
+	}
+	return e
+}
+
+func newHistoryStep(s *vow.Step) historyStep {
+	status, _ := stepStatus(s)
+	hs := historyStep{
+		Name:     s.Name,
+		Command:  s.Command(),
+		Status:   status,
+		ExitCode: s.ExitCode,
+		LogFile:  s.LogFile,
+	}
+	if !s.Start.IsZero() && !s.Running() {
+		hs.Duration = s.Duration().Seconds()
+	}
+	if status == "Failed" {
+		hs.Output = string(s.Output)
+	}
+	return hs
+}
+
+func (hs historyStep) label() string {
+	if hs.Name != "" {
+		return hs.Name
+	}
+	return hs.Command
+}
+
+// failed returns the first step that failed, if any did
+func (e historyEntry) failed() *historyStep {
+	for i, s := range e.Steps {
+		if s.Status == "Failed" {
+			return &e.Steps[i]
+		}
+	}
+	return nil
+}
+
+// readHistory returns the builds in the history file, oldest first.
+// There is no history if the file does not exist.
+func readHistory(file string) ([]historyEntry, error) {
+	in, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []historyEntry
+	s := bufio.NewScanner(bytes.NewReader(in))
+	s.Buffer(nil, len(in)+1)
+	for s.Scan() {
+		var e historyEntry
+		// a line that can't be read is skipped rather
+		// than losing the rest of the history
+		if err := json.Unmarshal(s.Bytes(), &e); err == nil {
+			entries = append(entries, e)
+		}
+	}
+	return entries, s.Err()
+}
+
+// appendHistory adds the build to the history file,
+// dropping the oldest builds past historyLimit
+func appendHistory(file string, e historyEntry) error {
+	entries, err := readHistory(file)
+	if err != nil {
+		return err
+	}
+	entries = append(entries, e)
+	if len(entries) > historyLimit {
+		entries = entries[len(entries)-historyLimit:]
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	// replace the file at once so it is never half written
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// lastHistoryBuild returns the number of the last build in the history file
+func lastHistoryBuild(file string) int {
+	// the numbers start over if the history can't be read
+	entries, _ := readHistory(file)
+	if len(entries) == 0 {
+		return 0
+	}
+	return entries[len(entries)-1].Build
+}
+
+// historyShown is how many builds snag history lists
+const historyShown = 20
+
+// writeHistory writes a line for each of the last builds, newest first
+func writeHistory(w io.Writer, entries []historyEntry) error {
+	if len(entries) == 0 {
+		_, err := fmt.Fprintln(w, "No builds yet")
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for i := len(entries) - 1; i >= 0 && i >= len(entries)-historyShown; i-- {
+		e := entries[i]
+		status := "Passed"
+		if !e.Passed {
+			status = "Failed"
+		}
+
+		var notes []string
+		if f := e.failed(); f != nil {
+			notes = append(notes, f.label()+" failed")
+		}
+		if e.TimedOut {
+			notes = append(notes, "timed out")
+		}
+		if e.Rerun {
+			notes = append(notes, "rerun")
+		}
+		if t := describeTrigger(e.Trigger); t != "" {
+			notes = append(notes, t)
+		}
+
+		fmt.Fprintf(tw, "#%d\t%s\t%s\t%s",
+			e.Build,
+			status,
+			e.Start.Format("2006-01-02 15:04:05"),
+			formatDuration(time.Duration(e.Duration*float64(time.Second))),
+		)
+		if len(notes) > 0 {
+			fmt.Fprintf(tw, "\t%s", strings.Join(notes, ", "))
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+// describeTrigger lists the first few files that started a build
+func describeTrigger(files []string) string {
+	const shown = 3
+	if len(files) <= shown {
+		return strings.Join(files, " ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(files[:shown], " "), len(files)-shown)
+}
+
+// writeBuild writes the status and full output of every step of the build.
+// The output comes from the logs of the build when they were kept.
+func writeBuild(w io.Writer, e historyEntry) error {
+	status := "Passed"
+	if !e.Passed {
+		status = "Failed"
+	}
+	fmt.Fprintf(w, "Build #%d %s in %s at %s\n",
+		e.Build,
+		status,
+		formatDuration(time.Duration(e.Duration*float64(time.Second))),
+		e.Start.Format("2006-01-02 15:04:05"),
+	)
+	if len(e.Trigger) > 0 {
+		fmt.Fprintf(w, "Changed: %s\n", strings.Join(e.Trigger, " "))
+	}
+
+	for _, s := range e.Steps {
+		fmt.Fprintf(w, "\n%-*s  %s", statusWidth, s.Status, s.Command)
+		if s.ExitCode > 0 {
+			fmt.Fprintf(w, "  exit code %d", s.ExitCode)
+		}
+		fmt.Fprintln(w)
+
+		out, err := ioutil.ReadFile(s.LogFile)
+		switch {
+		case s.LogFile != "" && err == nil:
+		case s.Output != "":
+			out = []byte(s.Output)
+		default:
+			continue
+		}
+		if _, err := w.Write(out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findBuild returns the build with the number n from the history
+func findBuild(entries []historyEntry, n int) (historyEntry, error) {
+	for _, e := range entries {
+		if e.Build == n {
+			return e, nil
+		}
+	}
+	return historyEntry{}, fmt.Errorf("build #%d is not in the history", n)
+}
+
+// lastFailure returns the last build that failed
+func lastFailure(entries []historyEntry) (historyEntry, error) {
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !entries[i].Passed {
+			return entries[i], nil
+		}
+	}
+	return historyEntry{}, errors.New("no build in the history failed")
+}
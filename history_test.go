@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Tonkpils/snag/vow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewHistoryEntry(t *testing.T) {
+	start := time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC)
+	res := &vow.Result{
+		Start: start,
+		End:   start.Add(3 * time.Second),
+		Steps: []*vow.Step{
+			{Args: []string{"go", "build"}, Start: start, End: start.Add(time.Second), Output: []byte("ok\n")},
+			{
+				Name:     "test",
+				Args:     []string{"go", "test"},
+				Start:    start,
+				End:      start.Add(2 * time.Second),
+				Err:      errors.New("exit status 1"),
+				ExitCode: 1,
+				Output:   []byte("FAIL\n"),
+				LogFile:  ".snag/logs/3/test.log",
+			},
+			{Args: []string{"go", "install"}, Skipped: true},
+		},
+	}
+
+	e := newHistoryEntry(3, []string{"main.go"}, res, false)
+	assert.Equal(t, 3, e.Build)
+	assert.False(t, e.Passed)
+	assert.Equal(t, float64(3), e.Duration)
+	assert.Equal(t, []string{"main.go"}, e.Trigger)
+	assert.Equal(t, []historyStep{
+		{Command: "go build", Status: "Passed", Duration: 1},
+		{Name: "test", Command: "go test", Status: "Failed", ExitCode: 1, Duration: 2, LogFile: ".snag/logs/3/test.log", Output: "FAIL\n"},
+		{Command: "go install", Status: "Skipped"},
+	}, e.Steps)
+	assert.Equal(t, "test", e.failed().label())
+}
+
+func TestAppendHistory(t *testing.T) {
+	wd, tmpDir := tmpDirectory(t)
+	defer os.RemoveAll(tmpDir)
+
+	chdir(t, tmpDir)
+	defer os.Chdir(wd)
+
+	entries, err := readHistory(historyFile)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+	assert.Equal(t, 0, lastHistoryBuild(historyFile))
+
+	for i := 1; i <= historyLimit+5; i++ {
+		require.NoError(t, appendHistory(historyFile, historyEntry{Build: i, Passed: i%2 == 0}))
+	}
+
+	entries, err = readHistory(historyFile)
+	require.NoError(t, err)
+	require.Len(t, entries, historyLimit)
+	assert.Equal(t, 6, entries[0].Build)
+	assert.Equal(t, historyLimit+5, lastHistoryBuild(historyFile))
+	assert.Equal(t, historyLimit+5, lastBuildNumber())
+
+	e, err := lastFailure(entries)
+	require.NoError(t, err)
+	assert.Equal(t, historyLimit+5, e.Build)
+
+	e, err = findBuild(entries, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 10, e.Build)
+
+	_, err = findBuild(entries, 1)
+	assert.EqualError(t, err, "build #1 is not in the history")
+}
+
+func TestWriteHistory(t *testing.T) {
+	start := time.Date(2016, 5, 1, 12, 0, 0, 0, time.Local)
+	entries := []historyEntry{
+		{Build: 1, Start: start, Duration: 1.5, Passed: true},
+		{
+			Build:    2,
+			Start:    start.Add(time.Minute),
+			Duration: 3,
+			Trigger:  []string{"a.go", "b.go", "c.go", "d.go"},
+			Steps:    []historyStep{{Command: "go build", Status: "Passed"}, {Name: "test", Command: "go test", Status: "Failed"}},
+		},
+		{Build: 3, Start: start.Add(2 * time.Minute), Duration: 0.2, Passed: true, Rerun: true},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, writeHistory(&buf, entries))
+
+	e := "#3  Passed  2016-05-01 12:02:00  200ms  rerun\n" +
+		"#2  Failed  2016-05-01 12:01:00  3.0s   test failed, a.go b.go c.go and 1 more\n" +
+		"#1  Passed  2016-05-01 12:00:00  1.5s\n"
+	assert.Equal(t, e, buf.String())
+
+	buf.Reset()
+	require.NoError(t, writeHistory(&buf, nil))
+	assert.Equal(t, "No builds yet\n", buf.String())
+}
+
+func TestWriteBuild(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "snag-history")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	log := filepath.Join(tmpDir, "build.log")
+	require.NoError(t, ioutil.WriteFile(log, []byte("full output\n"), 0644))
+
+	e := historyEntry{
+		Build:    4,
+		Start:    time.Date(2016, 5, 1, 12, 0, 0, 0, time.Local),
+		Duration: 2,
+		Trigger:  []string{"main.go"},
+		Steps: []historyStep{
+			{Command: "go build", Status: "Passed", LogFile: log},
+			{Command: "go test", Status: "Failed", ExitCode: 1, Output: "FAIL\n"},
+			{Command: "go install", Status: "Skipped"},
+		},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, writeBuild(&buf, e))
+
+	expected := "Build #4 Failed in 2.0s at 2016-05-01 12:00:00\n" +
+		"Changed: main.go\n" +
+		"\nPassed    go build\n" +
+		"full output\n" +
+		"\nFailed    go test  exit code 1\n" +
+		"FAIL\n" +
+		"\nSkipped   go install\n"
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestPastBuild(t *testing.T) {
+	entries := []historyEntry{{Build: 1}, {Build: 2, Passed: true}}
+
+	e, err := pastBuild(entries, "show", "#1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, e.Build)
+
+	e, err = pastBuild(entries, "last", "")
+	require.NoError(t, err)
+	assert.Equal(t, 1, e.Build)
+
+	_, err = pastBuild(entries, "show", "")
+	assert.EqualError(t, err, "usage: snag show <build number>")
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Tonkpils/snag/vow"
+)
+
+// the names of the hooks in the snag file
+const (
+	hookBeforeBuild = "before_build"
+	hookAfterBuild  = "after_build"
+	hookOnSuccess   = "on_success"
+	hookOnFailure   = "on_failure"
+	hookOnRecover   = "on_recover"
+	hookOnStart     = "on_start"
+	hookOnExit      = "on_exit"
+)
+
+// hookTimeout is how long a hook command can
+// run before it is stopped, so builds aren't held up
+const hookTimeout = 30 * time.Second
+
+// runHook runs the commands of the hook one after the other with
+// the variables in env. A command that fails is reported but doesn't
+// stop the rest of them and has no effect on the build.
+func (b *Bob) runHook(name string, env []string) {
+	env = append(env[:len(env):len(env)], "SNAG_HOOK="+name)
+	for _, args := range b.hooks[name] {
+		if err := runHookCommand(args, env); err != nil {
+			b.reporter.OnError(fmt.Errorf("%s hook %q failed: %s", name, strings.Join(args, " "), err))
+		}
+	}
+}
+
+func runHookCommand(args []string, env []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+	out, err := cmd.CombinedOutput()
+	if err != nil && len(out) > 0 {
+		return fmt.Errorf("%s\n%s", err, strings.TrimSpace(string(out)))
+	}
+	return err
+}
+
+// runResultHooks runs the hooks that follow a build: after_build,
+// then on_failure or on_success followed by on_recover when the
+// build before it failed. A rerun of the failed step is not a
+// build that passed or failed so it runs none of them.
+func (b *Bob) runResultHooks(run buildRun, res *vow.Result, recovered bool) {
+	if !run.full {
+		return
+	}
+
+	env := hookEnv(run, res)
+	b.runHook(hookAfterBuild, env)
+	if !res.Passed() {
+		b.runHook(hookOnFailure, env)
+		return
+	}
+	b.runHook(hookOnSuccess, env)
+	if recovered {
+		b.runHook(hookOnRecover, env)
+	}
+}
+
+// hookEnv returns the variables that tell a hook about
+// the build, res is nil when it hasn't finished yet
+func hookEnv(run buildRun, res *vow.Result) []string {
+	env := []string{
+		"SNAG_BUILD=" + strconv.Itoa(run.n),
+		"SNAG_TRIGGER=" + strings.Join(run.trigger, " "),
+	}
+	if res == nil {
+		return env
+	}
+
+	status := "passed"
+	if !res.Passed() {
+		status = "failed"
+	}
+	env = append(env,
+		"SNAG_STATUS="+status,
+		fmt.Sprintf("SNAG_DURATION=%.3f", res.Duration().Seconds()),
+	)
+	if res.TimedOut() {
+		env = append(env, "SNAG_TIMED_OUT=true")
+	}
+	if s := res.Failed(); s != nil {
+		env = append(env,
+			"SNAG_FAILED_STEP="+s.Label(),
+			"SNAG_EXIT_CODE="+strconv.Itoa(s.ExitCode),
+			"SNAG_LOG_FILE="+s.LogFile,
+		)
+	}
+	return env
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Tonkpils/snag/vow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newHookBuilder returns a builder whose hooks write
+// what they are told about the build to a file
+func newHookBuilder(t *testing.T, c config, dir string, names ...string) (*Bob, *keysReporter, func() string) {
+	out := filepath.Join(dir, "hooks")
+
+	b, r := newKeysBuilder(t, c)
+	b.hooks = make(map[string][][]string)
+	for _, name := range names {
+		b.hooks[name] = [][]string{{"sh", "-c", `echo "$SNAG_HOOK $SNAG_BUILD $SNAG_STATUS" >> ` + out}}
+	}
+
+	read := func() string {
+		b, _ := ioutil.ReadFile(out)
+		return string(b)
+	}
+	return b, r, read
+}
+
+func hooksDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "snag-hooks")
+	require.NoError(t, err)
+	return dir
+}
+
+func TestRunResultHooks(t *testing.T) {
+	tmpDir := hooksDir(t)
+	defer os.RemoveAll(tmpDir)
+
+	b, _, read := newHookBuilder(t, config{}, tmpDir,
+		hookAfterBuild, hookOnSuccess, hookOnFailure, hookOnRecover,
+	)
+	defer b.Close()
+
+	failed := &vow.Result{Steps: []*vow.Step{{Err: errors.New("exit status 1")}}}
+	passed := &vow.Result{Steps: []*vow.Step{{}}}
+
+	for _, build := range []struct {
+		run buildRun
+		res *vow.Result
+	}{
+		{buildRun{n: 1, full: true}, failed},
+		{buildRun{n: 2}, passed},
+		{buildRun{n: 3, full: true}, passed},
+		{buildRun{n: 4, full: true}, passed},
+	} {
+		b.runResultHooks(build.run, build.res, b.finish(build.run, build.res))
+	}
+
+	// the rerun of the failed step doesn't count
+	e := "after_build 1 failed\n" +
+		"on_failure 1 failed\n" +
+		"after_build 3 passed\n" +
+		"on_success 3 passed\n" +
+		"on_recover 3 passed\n" +
+		"after_build 4 passed\n" +
+		"on_success 4 passed\n"
+	assert.Equal(t, e, read())
+}
+
+func TestRunHook_BeforeBuildAndExit(t *testing.T) {
+	tmpDir := hooksDir(t)
+	defer os.RemoveAll(tmpDir)
+
+	b, r, read := newHookBuilder(t, config{Build: stepsFrom([]string{"echo hello"})}, tmpDir,
+		hookBeforeBuild, hookOnExit,
+	)
+
+	b.execute()
+	<-r.results
+	assert.Equal(t, "before_build 1 \n", read())
+
+	require.NoError(t, b.Close())
+	require.NoError(t, b.Close())
+	assert.Equal(t, "before_build 1 \non_exit 1 \n", read())
+}
+
+func TestRunHook_BeforeBuildUnlocked(t *testing.T) {
+	tmpDir := hooksDir(t)
+	defer os.RemoveAll(tmpDir)
+	release := filepath.Join(tmpDir, "release")
+
+	b, r := newKeysBuilder(t, config{Build: stepsFrom([]string{"echo hello"})})
+	defer b.Close()
+	b.hooks = map[string][][]string{
+		hookBeforeBuild: {{"sh", "-c", "while [ ! -f " + release + " ]; do sleep 0.01; done"}},
+	}
+
+	go b.execute()
+	for i := 0; r.buildCount() == 0 && i < 500; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the status doesn't wait for the hook
+	status := make(chan string)
+	go func() {
+		msg, _ := b.status()
+		status <- msg
+	}()
+	select {
+	case msg := <-status:
+		assert.Equal(t, "Build #1 is running", msg)
+	case <-time.After(2 * time.Second):
+		t.Fatal("the status waited for the before_build hook")
+	}
+
+	require.NoError(t, ioutil.WriteFile(release, nil, 0644))
+	res := <-r.results
+	assert.True(t, res.Passed())
+}
+
+func TestRunHook_Error(t *testing.T) {
+	b, r := newKeysBuilder(t, config{})
+	defer b.Close()
+	b.hooks = map[string][][]string{
+		hookOnStart: {{"sh", "-c", "echo oops; exit 2"}, {"true"}, {"sh", "-c", "exit 3"}},
+	}
+
+	b.runHook(hookOnStart, nil)
+	require.Len(t, r.errors, 2)
+	assert.EqualError(t, r.errors[0], "on_start hook \"sh -c echo oops; exit 2\" failed: exit status 2\noops")
+	assert.EqualError(t, r.errors[1], "on_start hook \"sh -c exit 3\" failed: exit status 3")
+}
+
+func TestHookEnv(t *testing.T) {
+	run := buildRun{n: 4, trigger: []string{"main.go", "foo/bar.go"}}
+	assert.Equal(t, []string{
+		"SNAG_BUILD=4",
+		"SNAG_TRIGGER=main.go foo/bar.go",
+	}, hookEnv(run, nil))
+
+	start := time.Now()
+	res := &vow.Result{
+		Start: start,
+		End:   start.Add(1500 * time.Millisecond),
+		Steps: []*vow.Step{
+			{Args: []string{"go", "build"}},
+			{Name: "test", Args: []string{"go", "test"}, Err: errors.New("exit status 1"), ExitCode: 1, LogFile: ".snag/logs/4/test.log"},
+		},
+	}
+	assert.Equal(t, []string{
+		"SNAG_BUILD=4",
+		"SNAG_TRIGGER=main.go foo/bar.go",
+		"SNAG_STATUS=failed",
+		"SNAG_DURATION=1.500",
+		"SNAG_FAILED_STEP=test",
+		"SNAG_EXIT_CODE=1",
+		"SNAG_LOG_FILE=.snag/logs/4/test.log",
+	}, hookEnv(run, res))
+}
+
+func TestNewBuilder_Hooks(t *testing.T) {
+	b, err := NewBuilder(config{Hooks: hooks{
+		OnFailure: commands{"notify-send 'build failed'"},
+		OnExit:    commands{"true", "echo bye"},
+	}})
+	require.NoError(t, err)
+	defer b.Close()
+
+	assert.Equal(t, [][]string{{"notify-send", "'build failed'"}}, b.hooks[hookOnFailure])
+	assert.Len(t, b.hooks[hookOnExit], 2)
+	assert.Empty(t, b.hooks[hookBeforeBuild])
+}
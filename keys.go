@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"io"
+)
+
+const keysHelp = `Keys:
+  r, enter  rebuild
+  p         pause or resume watching
+  v         show or hide the output of commands that passed
+  c         clear the screen
+  f         rerun the step that failed
+  q         quit
+  ?         show this help`
+
+// keyHandler is a reporter that can be controlled with keys
+// of its own, it reports whether it used the key
+type keyHandler interface {
+	handleKey(k byte) bool
+}
+
+// readKeys handles every key read from r until
+// it can't be read from or snag is closed
+func (b *Bob) readKeys(r io.Reader) {
+	br := bufio.NewReader(r)
+	for {
+		k, err := br.ReadByte()
+		if err != nil {
+			return
+		}
+
+		select {
+		case <-b.done:
+			return
+		default:
+		}
+		b.handleKey(k)
+	}
+}
+
+func (b *Bob) handleKey(k byte) {
+	if h, ok := b.reporter.(keyHandler); ok && h.handleKey(k) {
+		return
+	}
+
+	switch k {
+	case 'r', '\r', '\n':
+		b.execute()
+	case 'p':
+		b.togglePause()
+	case 'v':
+		b.toggleVerbose()
+	case 'f':
+		b.rerunFailed()
+	case 'q':
+		b.Close()
+	case '?':
+		b.reporter.OnMessage(keysHelp)
+	}
+}
+
+// togglePause stops or starts building on changes
+func (b *Bob) togglePause() {
+	b.stateMtx.Lock()
+	paused := b.paused
+	b.stateMtx.Unlock()
+
+	b.setPaused(!paused)
+}
+
+// setPaused stops or starts building on changes and reports whether it
+// wasn't already, a build starts on resume if anything changed while paused
+func (b *Bob) setPaused(paused bool) bool {
+	b.stateMtx.Lock()
+	if b.paused == paused {
+		b.stateMtx.Unlock()
+		return false
+	}
+	b.paused = paused
+	pending := b.pending
+	b.pending = false
+	b.stateMtx.Unlock()
+
+	if paused {
+		b.reporter.OnMessage("Paused, press p to resume")
+		return true
+	}
+
+	b.reporter.OnMessage("Resumed")
+	if pending {
+		b.execute()
+	}
+	return true
+}
+
+func (b *Bob) toggleVerbose() {
+	b.stateMtx.Lock()
+	b.verbose = !b.verbose
+	verbose := b.verbose
+	b.stateMtx.Unlock()
+
+	b.reporter.SetVerbose(verbose)
+	if verbose {
+		b.reporter.OnMessage("Showing the output of every command")
+	} else {
+		b.reporter.OnMessage("Showing the output of failed commands only")
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Tonkpils/snag/vow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// keysReporter records what snag says and the result of every build
+type keysReporter struct {
+	mtx      sync.Mutex
+	messages []string
+	builds   int
+	verbose  bool
+	errors   []error
+	results  chan *vow.Result
+}
+
+func (r *keysReporter) OnStart(s *vow.Step)                                {}
+func (r *keysReporter) OnOutput(s *vow.Step, line []byte)                  {}
+func (r *keysReporter) OnFinish(s *vow.Step)                               {}
+func (r *keysReporter) OnCancel()                                          {}
+func (r *keysReporter) OnWatch(dir string)                                 {}
+func (r *keysReporter) OnChange(path string)                               {}
+func (r *keysReporter) OnResult(res *vow.Result, full bool, avg *averages) { r.results <- res }
+func (r *keysReporter) SetVerbose(verbose bool)                            { r.verbose = verbose }
+func (r *keysReporter) OnMessage(msg string)                               { r.messages = append(r.messages, msg) }
+
+func (r *keysReporter) OnBuild(warning string) {
+	r.mtx.Lock()
+	r.builds++
+	r.mtx.Unlock()
+}
+
+func (r *keysReporter) OnError(err error) {
+	r.mtx.Lock()
+	r.errors = append(r.errors, err)
+	r.mtx.Unlock()
+}
+
+func (r *keysReporter) buildCount() int {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.builds
+}
+
+func newKeysBuilder(t *testing.T, c config) (*Bob, *keysReporter) {
+	b, err := NewBuilder(c)
+	require.NoError(t, err)
+
+	r := &keysReporter{results: make(chan *vow.Result, 1)}
+	b.reporter = r
+	b.history = ""
+	return b, r
+}
+
+func TestHandleKey_Pause(t *testing.T) {
+	b, r := newKeysBuilder(t, config{})
+	defer b.Close()
+
+	b.handleKey('p')
+	b.queue("main.go")
+	b.queue("main_test.go")
+	assert.Equal(t, 0, r.buildCount())
+
+	b.handleKey('p')
+	<-r.results
+	assert.Equal(t, 1, r.buildCount())
+	assert.Equal(t, []string{"Paused, press p to resume", "Resumed"}, r.messages)
+
+	// nothing changed while paused this time
+	b.handleKey('p')
+	b.handleKey('p')
+	assert.Equal(t, 1, r.buildCount())
+}
+
+func TestHandleKey_Verbose(t *testing.T) {
+	b, r := newKeysBuilder(t, config{Verbose: true})
+	defer b.Close()
+
+	b.handleKey('v')
+	assert.False(t, r.verbose)
+	b.handleKey('v')
+	assert.True(t, r.verbose)
+	assert.Len(t, r.messages, 2)
+}
+
+func TestHandleKey_Help(t *testing.T) {
+	b, r := newKeysBuilder(t, config{})
+	defer b.Close()
+
+	b.handleKey('?')
+	b.handleKey('x')
+	require.Len(t, r.messages, 1)
+	assert.Equal(t, keysHelp, r.messages[0])
+}
+
+func TestHandleKey_RerunFailed(t *testing.T) {
+	b, r := newKeysBuilder(t, config{
+		Build: stepsFrom([]string{"echo hello", "false", "echo done"}),
+	})
+	defer b.Close()
+
+	b.handleKey('f')
+	assert.Equal(t, []string{"There is no failed step to rerun"}, r.messages)
+
+	b.handleKey('r')
+	res := <-r.results
+	require.Len(t, res.Steps, 3)
+	assert.False(t, res.Passed())
+
+	b.handleKey('f')
+	res = <-r.results
+	require.Len(t, res.Steps, 1)
+	assert.Equal(t, "false", res.Steps[0].Command())
+	assert.Equal(t, 2, r.buildCount())
+}
+
+func TestHandleKey_RerunFailedRegistered(t *testing.T) {
+	b, r := newKeysBuilder(t, config{
+		Build: []step{
+			{Cmd: "echo abc", Register: "SHA"},
+			{Cmd: "test $$SHA = def"},
+		},
+	})
+	defer b.Close()
+
+	b.handleKey('r')
+	res := <-r.results
+	require.Len(t, res.Steps, 2)
+	assert.Equal(t, []string{"test", "abc", "=", "def"}, res.Steps[1].Args)
+
+	// the rerun gets what the build registered
+	b.handleKey('f')
+	res = <-r.results
+	require.Len(t, res.Steps, 1)
+	assert.Equal(t, []string{"test", "abc", "=", "def"}, res.Steps[0].Args)
+	assert.EqualValues(t, 1, res.Steps[0].ExitCode)
+}
+
+func TestHandleKey_Quit(t *testing.T) {
+	b, _ := newKeysBuilder(t, config{})
+
+	done := make(chan struct{})
+	go func() {
+		b.readKeys(strings.NewReader("?q"))
+		close(done)
+	}()
+
+	select {
+	case <-b.done:
+	case <-time.After(time.Second):
+		t.Fatal("snag was not closed")
+	}
+	<-done
+}
+
+func TestFailedCommand(t *testing.T) {
+	cmds := flatten([]command{
+		{args: []string{"go", "build"}},
+		{parallel: []command{{args: []string{"go", "vet"}}, {args: []string{"golint"}}}},
+		{args: []string{"go", "test"}},
+	})
+	require.Len(t, cmds, 4)
+
+	res := &vow.Result{Steps: []*vow.Step{{}, {}, {Err: errors.New("exit status 1")}, {Skipped: true}}}
+	c := failedCommand(res, cmds)
+	require.NotNil(t, c)
+	assert.Equal(t, []string{"golint"}, c.args)
+
+	res.Steps[2].Err = nil
+	assert.Nil(t, failedCommand(res, cmds))
+}
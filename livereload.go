@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/Tonkpils/snag/vow"
+)
+
+// liveReloadAddr is where browsers expect to find a LiveReload server
+const liveReloadAddr = ":35729"
+
+// liveReloadProtocol is the version of the LiveReload protocol snag speaks
+const liveReloadProtocol = "http://livereload.com/protocols/official-7"
+
+// liveReload tells the browsers connected to it to reload once a build
+// passes and the run commands with a ready pattern have written it.
+// When only stylesheets changed they are swapped without a reload.
+type liveReload struct {
+	nopReporter
+
+	ln      net.Listener
+	clients *broadcaster
+
+	mtx      sync.Mutex
+	dir      string
+	queued   []string
+	changes  []string
+	ready    readiness
+	passed   bool
+	reloaded bool
+}
+
+func newLiveReload(runCmds []command) *liveReload {
+	return &liveReload{clients: newBroadcaster(), ready: newReadiness(runCmds)}
+}
+
+// listenLiveReload starts a LiveReload server on addr
+func listenLiveReload(addr string, runCmds []command) (*liveReload, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	l := newLiveReload(runCmds)
+	l.ln = ln
+	go http.Serve(ln, l.handler())
+	return l, nil
+}
+
+// Close stops listening and disconnects the browsers
+func (l *liveReload) Close() error {
+	l.clients.close()
+	if l.ln == nil {
+		return nil
+	}
+	return l.ln.Close()
+}
+
+func (l *liveReload) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/livereload", l.serveWebsocket)
+	mux.HandleFunc("/livereload.js", serveLiveReloadJS)
+	return mux
+}
+
+func serveLiveReloadJS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/javascript")
+	w.Write([]byte(liveReloadJS))
+}
+
+// liveReloadMessage is a command of the LiveReload protocol
+type liveReloadMessage struct {
+	Command    string   `json:"command"`
+	Protocols  []string `json:"protocols,omitempty"`
+	ServerName string   `json:"serverName,omitempty"`
+	Path       string   `json:"path,omitempty"`
+	LiveCSS    bool     `json:"liveCSS,omitempty"`
+}
+
+// serveWebsocket sends the reloads to a browser
+// until it goes away or snag is closed
+func (l *liveReload) serveWebsocket(w http.ResponseWriter, r *http.Request) {
+	conn, rw, err := upgradeWebsocket(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	msgs := l.clients.subscribe()
+	defer l.clients.unsubscribe(msgs)
+
+	var mtx sync.Mutex
+	send := func(op byte, payload []byte) error {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if err := writeFrame(rw, op, payload); err != nil {
+			return err
+		}
+		return rw.Flush()
+	}
+
+	go func() {
+		for msg := range msgs {
+			if err := send(opText, msg); err != nil {
+				break
+			}
+		}
+		// stop reading once snag is closed or the browser is gone
+		conn.Close()
+	}()
+
+	fr := &frameReader{r: rw}
+	for {
+		op, payload, err := fr.readFrame()
+		if err != nil {
+			return
+		}
+
+		switch op {
+		case opText:
+			var msg liveReloadMessage
+			if err := json.Unmarshal(payload, &msg); err == nil && msg.Command == "hello" {
+				hello, _ := json.Marshal(liveReloadMessage{
+					Command:    "hello",
+					Protocols:  []string{liveReloadProtocol},
+					ServerName: "snag",
+				})
+				send(opText, hello)
+			}
+		case opPing:
+			send(opPong, payload)
+		case opClose:
+			send(opClose, nil)
+			return
+		}
+	}
+}
+
+func (l *liveReload) OnWatch(dir string) {
+	l.mtx.Lock()
+	l.dir = dir
+	l.mtx.Unlock()
+}
+
+func (l *liveReload) OnChange(path string) {
+	l.mtx.Lock()
+	if rel, err := filepath.Rel(l.dir, path); err == nil && l.dir != "" {
+		path = rel
+	}
+	l.queued = append(l.queued, filepath.ToSlash(path))
+	l.mtx.Unlock()
+}
+
+func (l *liveReload) OnBuild(warning string) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.changes, l.queued = l.queued, nil
+	l.passed, l.reloaded = false, false
+	l.ready.reset()
+}
+
+// OnOutput looks for the run commands saying they are ready
+func (l *liveReload) OnOutput(s *vow.Step, line []byte) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.ready.see(s, line) {
+		l.maybeReload()
+	}
+}
+
+// OnResult reloads the browsers once a build passed, the run commands
+// aren't started by a rerun of the step that failed so it is left out
+func (l *liveReload) OnResult(res *vow.Result, full bool, avg *averages) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.passed = full && res.Passed()
+	l.maybeReload()
+}
+
+// maybeReload reloads the browsers once the build passed and every run
+// command that has a ready pattern is ready, l.mtx must be held
+func (l *liveReload) maybeReload() {
+	if !l.passed || l.reloaded || !l.ready.ready() {
+		return
+	}
+	l.reloaded = true
+
+	for _, msg := range reloadMessages(l.changes) {
+		out, _ := json.Marshal(msg)
+		l.clients.Write(out)
+	}
+}
+
+// reloadMessages returns the stylesheets to swap when only they
+// changed, otherwise the page is reloaded
+func reloadMessages(changes []string) []liveReloadMessage {
+	page := []liveReloadMessage{{Command: "reload", Path: "/"}}
+	if len(changes) == 0 {
+		return page
+	}
+
+	var msgs []liveReloadMessage
+	for _, c := range changes {
+		if !strings.EqualFold(filepath.Ext(c), ".css") {
+			page[0].Path = c
+			return page
+		}
+		msgs = append(msgs, liveReloadMessage{Command: "reload", Path: c, LiveCSS: true})
+	}
+	return msgs
+}
+
+// liveReloadJS is served as /livereload.js for pages to include, it speaks
+// just enough of the protocol to reload the page and swap stylesheets
+const liveReloadJS = `(function () {
+  var script = document.currentScript;
+  var match = script && script.src.match(/^https?:\/\/([^\/]+)/);
+  var host = match ? match[1] : location.hostname + ':35729';
+
+  function swapStylesheets(path) {
+    var name = path.split('/').pop();
+    var links = document.querySelectorAll('link[rel="stylesheet"]');
+    var swapped = false;
+    for (var i = 0; i < links.length; i++) {
+      var href = links[i].href.replace(/[?#].*$/, '');
+      if (href.split('/').pop() === name) {
+        links[i].href = href + '?livereload=' + Date.now();
+        swapped = true;
+      }
+    }
+    return swapped;
+  }
+
+  function connect() {
+    var ws = new WebSocket('ws://' + host + '/livereload');
+    ws.onopen = function () {
+      ws.send(JSON.stringify({command: 'hello', protocols: ['` + liveReloadProtocol + `']}));
+    };
+    ws.onmessage = function (e) {
+      var msg = JSON.parse(e.data);
+      if (msg.command !== 'reload') {
+        return;
+      }
+      if (msg.liveCSS && /\.css$/i.test(msg.path) && swapStylesheets(msg.path)) {
+        return;
+      }
+      location.reload();
+    };
+    ws.onclose = function () {
+      setTimeout(connect, 1000);
+    };
+  }
+
+  connect();
+})();
+`
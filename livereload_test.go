@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/Tonkpils/snag/vow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clientFrame returns a single masked frame the way a browser sends it
+func clientFrame(op byte, payload []byte) []byte {
+	mask := []byte{0x37, 0xfa, 0x21, 0x3d}
+	frame := []byte{0x80 | op, 0x80 | byte(len(payload))}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+func TestWebsocketAccept(t *testing.T) {
+	// the example from RFC 6455
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", websocketAccept("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+func TestReadFrame(t *testing.T) {
+	fr := &frameReader{r: bytes.NewReader(clientFrame(opText, []byte("Hello")))}
+	op, payload, err := fr.readFrame()
+	require.NoError(t, err)
+	assert.Equal(t, byte(opText), op)
+	assert.Equal(t, "Hello", string(payload))
+
+	// a message in two frames with a ping in between
+	in := []byte{0x01, 0x03, 'H', 'e', 'l', 0x89, 0x00, 0x80, 0x02, 'l', 'o'}
+	fr = &frameReader{r: bytes.NewReader(in)}
+	op, _, err = fr.readFrame()
+	require.NoError(t, err)
+	assert.Equal(t, byte(opPing), op)
+	op, payload, err = fr.readFrame()
+	require.NoError(t, err)
+	assert.Equal(t, byte(opText), op)
+	assert.Equal(t, "Hello", string(payload))
+
+	fr = &frameReader{r: bytes.NewReader([]byte{0x81, 127, 0, 0, 0, 0, 0, 1, 0, 1})}
+	_, _, err = fr.readFrame()
+	assert.Equal(t, errFrameTooLarge, err)
+}
+
+func TestWriteFrame(t *testing.T) {
+	tests := []struct {
+		Size   int
+		Header []byte
+	}{
+		{5, []byte{0x81, 5}},
+		{200, []byte{0x81, 126, 0, 200}},
+		{70000, []byte{0x81, 127, 0, 0, 0, 0, 0, 1, 0x11, 0x70}},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		require.NoError(t, writeFrame(&buf, opText, make([]byte, test.Size)))
+		assert.Equal(t, test.Header, buf.Bytes()[:len(test.Header)])
+		assert.Equal(t, len(test.Header)+test.Size, buf.Len())
+	}
+}
+
+func TestReloadMessages(t *testing.T) {
+	assert.Equal(t, []liveReloadMessage{{Command: "reload", Path: "/"}}, reloadMessages(nil))
+	assert.Equal(t, []liveReloadMessage{
+		{Command: "reload", Path: "web/site.css", LiveCSS: true},
+		{Command: "reload", Path: "web/print.CSS", LiveCSS: true},
+	}, reloadMessages([]string{"web/site.css", "web/print.CSS"}))
+	assert.Equal(t, []liveReloadMessage{{Command: "reload", Path: "main.go"}}, reloadMessages([]string{"web/site.css", "main.go"}))
+}
+
+// reloads returns the messages sent to browsers so far
+func reloads(c chan []byte) []liveReloadMessage {
+	var msgs []liveReloadMessage
+	for {
+		select {
+		case out := <-c:
+			var msg liveReloadMessage
+			json.Unmarshal(out, &msg)
+			msgs = append(msgs, msg)
+		default:
+			return msgs
+		}
+	}
+}
+
+func TestLiveReload(t *testing.T) {
+	l := newLiveReload(nil)
+	c := l.clients.subscribe()
+
+	passed := &vow.Result{Steps: []*vow.Step{{}}}
+	failed := &vow.Result{Steps: []*vow.Step{{Err: errors.New("exit status 1")}}}
+
+	l.OnWatch("/foo")
+	l.OnChange("/foo/web/site.css")
+	l.OnBuild("")
+	l.OnResult(passed, true, nil)
+	assert.Equal(t, []liveReloadMessage{{Command: "reload", Path: "web/site.css", LiveCSS: true}}, reloads(c))
+
+	l.OnChange("/foo/main.go")
+	l.OnBuild("")
+	l.OnResult(failed, true, nil)
+	assert.Empty(t, reloads(c))
+
+	// the rerun of the step that failed is not enough
+	l.OnBuild("")
+	l.OnResult(passed, false, nil)
+	assert.Empty(t, reloads(c))
+
+	// a build started with a key reloads the page
+	l.OnBuild("")
+	l.OnResult(passed, true, nil)
+	assert.Equal(t, []liveReloadMessage{{Command: "reload", Path: "/"}}, reloads(c))
+}
+
+func TestLiveReload_Ready(t *testing.T) {
+	l := newLiveReload([]command{
+		{name: "server", args: []string{"./server"}, ready: regexp.MustCompile(`listening on :\d+`)},
+		{args: []string{"npm", "run", "watch"}},
+	})
+	c := l.clients.subscribe()
+
+	server := &vow.Step{Name: "server", Args: []string{"./server"}, Async: true}
+	watch := &vow.Step{Args: []string{"npm", "run", "watch"}, Async: true}
+
+	l.OnBuild("")
+	l.OnResult(&vow.Result{Steps: []*vow.Step{server, watch}}, true, nil)
+	l.OnOutput(watch, []byte("listening on :8080\n"))
+	l.OnOutput(server, []byte("starting\n"))
+	assert.Empty(t, reloads(c))
+
+	l.OnOutput(server, []byte("listening on :8080\n"))
+	assert.Len(t, reloads(c), 1)
+
+	// only once per build
+	l.OnOutput(server, []byte("listening on :8080\n"))
+	assert.Empty(t, reloads(c))
+
+	// the server can be ready before the build has finished
+	l.OnBuild("")
+	l.OnOutput(server, []byte("listening on :8080\n"))
+	assert.Empty(t, reloads(c))
+	l.OnResult(&vow.Result{Steps: []*vow.Step{server, watch}}, true, nil)
+	assert.Len(t, reloads(c), 1)
+}
+
+func TestLiveReload_Websocket(t *testing.T) {
+	l := newLiveReload(nil)
+	srv := httptest.NewServer(l.handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/livereload")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = http.Get(srv.URL + "/livereload.js")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("GET /livereload HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"))
+	require.NoError(t, err)
+
+	br := bufio.NewReader(conn)
+	fr := &frameReader{r: br}
+	resp, err = http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Header.Get("Sec-WebSocket-Accept"))
+
+	hello := `{"command":"hello","protocols":["` + liveReloadProtocol + `"]}`
+	_, err = conn.Write(clientFrame(opText, []byte(hello)))
+	require.NoError(t, err)
+
+	op, payload, err := fr.readFrame()
+	require.NoError(t, err)
+	assert.Equal(t, byte(opText), op)
+	assert.Equal(t, `{"command":"hello","protocols":["`+liveReloadProtocol+`"],"serverName":"snag"}`, string(payload))
+
+	l.OnBuild("")
+	l.OnResult(&vow.Result{}, true, nil)
+	op, payload, err = fr.readFrame()
+	require.NoError(t, err)
+	assert.Equal(t, byte(opText), op)
+	assert.Equal(t, `{"command":"reload","path":"/"}`, string(payload))
+
+	_, err = conn.Write(clientFrame(opPing, []byte("hi")))
+	require.NoError(t, err)
+	op, payload, err = fr.readFrame()
+	require.NoError(t, err)
+	assert.Equal(t, byte(opPong), op)
+	assert.Equal(t, "hi", string(payload))
+
+	// the browser is let go once snag is closed
+	require.NoError(t, l.Close())
+	_, err = br.ReadByte()
+	assert.Error(t, err)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// logsDir holds a directory with the output of the
+// commands of each build, named after the build's number
+var logsDir = filepath.Join(snagDir, "logs")
+
+// lastBuild returns the number of the last build that kept its logs in dir
+func lastBuild(dir string) int {
+	var last int
+	for _, n := range buildLogs(dir) {
+		if n > last {
+			last = n
+		}
+	}
+	return last
+}
+
+// pruneLogs removes the logs of every build in dir but the last keep
+func pruneLogs(dir string, keep int) error {
+	last := lastBuild(dir)
+	for _, n := range buildLogs(dir) {
+		if n > last-keep {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, strconv.Itoa(n))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildLogs returns the numbers of the builds with logs in dir
+func buildLogs(dir string) []int {
+	// there are no logs if dir can't be read
+	fis, _ := ioutil.ReadDir(dir)
+
+	var builds []int
+	for _, fi := range fis {
+		n, err := strconv.Atoi(fi.Name())
+		if err != nil || !fi.IsDir() {
+			continue
+		}
+		builds = append(builds, n)
+	}
+	return builds
+}
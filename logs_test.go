@@ -0,0 +1,31 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPruneLogs(t *testing.T) {
+	wd, tmpDir := tmpDirectory(t)
+	defer os.RemoveAll(tmpDir)
+
+	chdir(t, tmpDir)
+	defer os.Chdir(wd)
+
+	assert.Equal(t, 0, lastBuild(logsDir))
+
+	for _, d := range []string{"1", "2", "9", "10", "other"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(logsDir, d), 0755))
+	}
+	assert.Equal(t, 10, lastBuild(logsDir))
+
+	require.NoError(t, pruneLogs(logsDir, 2))
+	assert.Equal(t, []int{10, 9}, buildLogs(logsDir))
+
+	_, err := os.Stat(filepath.Join(logsDir, "other"))
+	assert.NoError(t, err, "only build logs should be pruned")
+}
@@ -3,8 +3,15 @@ package main
 import (
 	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/mattn/go-isatty"
 )
 
 const (
@@ -14,6 +21,10 @@ const (
 
 const SnagFile = ".snag.yml"
 
+// snagDir is where snag keeps anything it needs to
+// remember, it is never watched for changes
+const snagDir = ".snag"
+
 func init() {
 	log.SetOutput(os.Stdout)
 	log.SetFlags(0)
@@ -39,17 +50,46 @@ func main() {
 		log.Fatal(err)
 	}
 
+	if c.UI && !isatty.IsTerminal(os.Stdout.Fd()) {
+		log.Fatal("the dashboard can only be shown in a terminal")
+	}
+
 	b, err := NewBuilder(c)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer b.Close()
 
+	// other terminals control snag through its socket, which
+	// is optional so snag still runs when it can't listen
+	if ctl, err := listenControl(b, controlSocket); err != nil {
+		b.reporter.OnError(fmt.Errorf("snag can't be controlled from other terminals: %s", err))
+	} else {
+		defer ctl.Close()
+	}
+
+	// commands run in their own process group so they
+	// need to be stopped before snag exits
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		b.Close()
+	}()
+
 	wd, err := os.Getwd()
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	// keys are only read when someone can press them
+	if isatty.IsTerminal(os.Stdin.Fd()) {
+		if restore, err := makeRaw(os.Stdin); err == nil {
+			defer restore()
+			go b.readKeys(os.Stdin)
+		}
+	}
+
 	b.Watch(wd)
 }
 
@@ -57,6 +97,38 @@ func handleSubCommand(cmd string) error {
 	switch flag.Arg(0) {
 	case "init":
 		return initSnag()
+	case "explain":
+		c, err := parseConfig()
+		if err != nil {
+			return err
+		}
+		return explain(os.Stdout, c)
+	case "history":
+		entries, err := readHistory(historyFile)
+		if err != nil {
+			return err
+		}
+		return writeHistory(os.Stdout, entries)
+	case "show", "last":
+		entries, err := readHistory(historyFile)
+		if err != nil {
+			return err
+		}
+		e, err := pastBuild(entries, cmd, flag.Arg(1))
+		if err != nil {
+			return err
+		}
+		return writeBuild(os.Stdout, e)
+	case "trigger", "pause", "resume", "status", "stop":
+		reply, err := sendControl(controlSocket, cmd)
+		if err != nil {
+			return err
+		}
+		if reply.Failed {
+			return errors.New(reply.Message)
+		}
+		log.Println(reply.Message)
+		return nil
 	case "version":
 		log.Println(VersionOutput)
 		return nil
@@ -66,6 +138,20 @@ func handleSubCommand(cmd string) error {
 	}
 }
 
+// pastBuild returns the build asked for with snag show <n>,
+// or the last one that failed with snag last
+func pastBuild(entries []historyEntry, cmd, arg string) (historyEntry, error) {
+	if cmd == "last" {
+		return lastFailure(entries)
+	}
+
+	n, err := strconv.Atoi(strings.TrimPrefix(arg, "#"))
+	if err != nil {
+		return historyEntry{}, errors.New("usage: snag show <build number>")
+	}
+	return findBuild(entries, n)
+}
+
 func initSnag() error {
 	if _, err := os.Stat(SnagFile); err == nil {
 		return errors.New("snag file already exists")
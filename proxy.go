@@ -0,0 +1,322 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Tonkpils/snag/vow"
+)
+
+// the states of the app behind the proxy
+const (
+	proxyBuilding = iota
+	proxyStarting
+	proxyUp
+	proxyFailed
+	proxyClosed
+)
+
+const (
+	// proxyHold is how long a request is held
+	// before giving up on the app coming up
+	proxyHold = 2 * time.Minute
+
+	// proxyDialInterval is how often the proxy checks
+	// whether the app accepts connections while it starts
+	proxyDialInterval = 100 * time.Millisecond
+)
+
+// proxyWaitPath is requested by the page of a failed build,
+// it responds once there is something new to see
+const proxyWaitPath = "/__snag/wait"
+
+// buildProxy forwards requests to the app started by the run commands. It
+// holds them while a build is running and the app is starting, which is
+// once the run commands are ready and it accepts connections. A page with
+// the errors of the step that failed is served instead when a build fails.
+type buildProxy struct {
+	nopReporter
+
+	ln     net.Listener
+	target *url.URL
+	proxy  *httputil.ReverseProxy
+
+	// changed is closed and replaced whenever the state changes,
+	// build counts the builds so a check for an app that is
+	// starting can tell it is no longer needed
+	mtx     sync.Mutex
+	state   int
+	changed chan struct{}
+	build   int
+	failure buildFailure
+	ready   readiness
+}
+
+// buildFailure is what the page of a failed build shows
+type buildFailure struct {
+	Step     string
+	Command  string
+	ExitCode int
+	TimedOut bool
+	Output   string
+}
+
+func newBuildProxy(target *url.URL, runCmds []command) *buildProxy {
+	return &buildProxy{
+		target:  target,
+		proxy:   httputil.NewSingleHostReverseProxy(target),
+		changed: make(chan struct{}),
+		ready:   newReadiness(runCmds),
+	}
+}
+
+// listenProxy starts the proxy in front of the app on listen
+func listenProxy(listen string, target *url.URL, runCmds []command) (*buildProxy, error) {
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, err
+	}
+
+	p := newBuildProxy(target, runCmds)
+	p.ln = ln
+	go http.Serve(ln, p)
+	return p, nil
+}
+
+// proxyTarget returns the url of the app, the scheme
+// and host can be left out for an app on localhost
+func proxyTarget(target string) (*url.URL, error) {
+	raw := target
+	if strings.HasPrefix(raw, ":") {
+		raw = "localhost" + raw
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy target %q", target)
+	}
+	return u, nil
+}
+
+// Close stops listening and lets go of the requests being held
+func (p *buildProxy) Close() error {
+	p.setState(proxyClosed)
+	if p.ln == nil {
+		return nil
+	}
+	return p.ln.Close()
+}
+
+// setState changes the state and wakes up whoever waits for it
+func (p *buildProxy) setState(state int) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.setStateLocked(state)
+}
+
+// setStateLocked is setState for when p.mtx is already held
+func (p *buildProxy) setStateLocked(state int) {
+	if p.state == proxyClosed {
+		return
+	}
+	p.state = state
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+func (p *buildProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	timeout := time.NewTimer(proxyHold)
+	defer timeout.Stop()
+
+	if r.URL.Path == proxyWaitPath {
+		p.serveWait(w, r, timeout.C)
+		return
+	}
+
+	for {
+		p.mtx.Lock()
+		state, changed, failure := p.state, p.changed, p.failure
+		p.mtx.Unlock()
+
+		switch state {
+		case proxyUp:
+			p.proxy.ServeHTTP(w, r)
+			return
+		case proxyFailed:
+			serveFailure(w, failure)
+			return
+		case proxyClosed:
+			http.Error(w, "snag is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-timeout.C:
+			http.Error(w, "the app did not start in time", http.StatusGatewayTimeout)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// serveWait responds once the state of the app changes
+func (p *buildProxy) serveWait(w http.ResponseWriter, r *http.Request, timeout <-chan time.Time) {
+	p.mtx.Lock()
+	changed := p.changed
+	p.mtx.Unlock()
+
+	select {
+	case <-changed:
+		w.WriteHeader(http.StatusNoContent)
+	case <-timeout:
+		w.WriteHeader(http.StatusNoContent)
+	case <-r.Context().Done():
+	}
+}
+
+var failureTemplate = template.Must(template.New("failure").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Build failed</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { color: #c0392b; }
+pre { background: #f6f6f6; border-left: 4px solid #c0392b; padding: 1em; overflow: auto; }
+</style>
+</head>
+<body>
+<h1>Build failed</h1>
+{{if .TimedOut}}<p>The build timed out.</p>{{end}}
+{{if .Command}}<p><code>{{.Command}}</code>{{if ne .Step .Command}} ({{.Step}}){{end}} exited with code {{.ExitCode}}</p>{{end}}
+{{if .Output}}<pre>{{.Output}}</pre>{{end}}
+<p>This page reloads once the next build is done.</p>
+<script>
+(function wait() {
+  fetch('` + proxyWaitPath + `').then(function () { location.reload(); }, function () { setTimeout(wait, 1000); });
+})();
+</script>
+</body>
+</html>
+`))
+
+func serveFailure(w http.ResponseWriter, f buildFailure) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusInternalServerError)
+	failureTemplate.Execute(w, f)
+}
+
+// newBuildFailure keeps the errors of the step that failed the build
+func newBuildFailure(res *vow.Result) buildFailure {
+	f := buildFailure{TimedOut: res.TimedOut()}
+	s := res.Failed()
+	if s == nil {
+		return f
+	}
+
+	f.Step = s.Label()
+	f.Command = s.Command()
+	f.ExitCode = s.ExitCode
+
+	// the output is shown as text, without colors or progress bars
+	lines := strings.SplitAfter(string(s.Output), "\n")
+	for i, line := range lines {
+		lines[i] = cleanLine(line)
+	}
+	f.Output = strings.TrimSpace(strings.Join(lines, "\n"))
+	return f
+}
+
+func (p *buildProxy) OnBuild(warning string) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.build++
+	p.ready.reset()
+	p.setStateLocked(proxyBuilding)
+}
+
+// OnOutput looks for the run commands saying they are ready
+func (p *buildProxy) OnOutput(s *vow.Step, line []byte) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.ready.see(s, line) {
+		p.maybeStart()
+	}
+}
+
+// OnResult waits for the app to start once a build passed. Rerunning
+// the step that failed doesn't start the app, so the page of the
+// failed build is served until a whole build passes.
+func (p *buildProxy) OnResult(res *vow.Result, full bool, avg *averages) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if !full && res.Passed() {
+		p.setStateLocked(proxyFailed)
+		return
+	}
+	if !res.Passed() {
+		p.failure = newBuildFailure(res)
+		p.setStateLocked(proxyFailed)
+		return
+	}
+	p.setStateLocked(proxyStarting)
+	p.maybeStart()
+}
+
+// maybeStart waits for the app to accept connections once the build passed
+// and every run command is ready, p.mtx must be held
+func (p *buildProxy) maybeStart() {
+	if p.state != proxyStarting || !p.ready.ready() {
+		return
+	}
+	go p.waitForApp(p.build)
+}
+
+// waitForApp marks the app as up once it accepts connections,
+// unless another build started in the meantime
+func (p *buildProxy) waitForApp(build int) {
+	addr := p.target.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		port := "80"
+		if p.target.Scheme == "https" {
+			port = "443"
+		}
+		addr = net.JoinHostPort(addr, port)
+	}
+
+	for {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err == nil {
+			conn.Close()
+		}
+
+		p.mtx.Lock()
+		if p.build != build || p.state != proxyStarting {
+			p.mtx.Unlock()
+			return
+		}
+		if err == nil {
+			p.setStateLocked(proxyUp)
+			p.mtx.Unlock()
+			return
+		}
+		p.mtx.Unlock()
+
+		time.Sleep(proxyDialInterval)
+	}
+}
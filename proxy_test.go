@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/Tonkpils/snag/vow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// proxyResponse is what a request made through the proxy got back
+type proxyResponse struct {
+	code int
+	body string
+}
+
+// getAsync requests url in the background
+func getAsync(url string) chan proxyResponse {
+	c := make(chan proxyResponse, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			c <- proxyResponse{body: err.Error()}
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		c <- proxyResponse{code: resp.StatusCode, body: string(body)}
+	}()
+	return c
+}
+
+// assertHeld makes sure the request has not been answered yet
+func assertHeld(t *testing.T, c chan proxyResponse) {
+	select {
+	case resp := <-c:
+		t.Fatalf("expected the request to be held, got %d %q", resp.code, resp.body)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// awaitResponse returns the answer to the request
+func awaitResponse(t *testing.T, c chan proxyResponse) proxyResponse {
+	select {
+	case resp := <-c:
+		return resp
+	case <-time.After(5 * time.Second):
+		t.Fatal("the request was never answered")
+	}
+	return proxyResponse{}
+}
+
+// newTestProxy starts a proxy in front of an app that says hello,
+// stop stops both of them
+func newTestProxy(t *testing.T, runCmds []command) (p *buildProxy, front *httptest.Server, stop func()) {
+	app := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello from " + r.URL.Path))
+	}))
+	target, err := url.Parse(app.URL)
+	require.NoError(t, err)
+
+	p = newBuildProxy(target, runCmds)
+	front = httptest.NewServer(p)
+	return p, front, func() {
+		front.Close()
+		app.Close()
+	}
+}
+
+func TestProxyTarget(t *testing.T) {
+	tests := map[string]string{
+		":3001":                   "http://localhost:3001",
+		"app.local:8080":          "http://app.local:8080",
+		"https://app.local:8443/": "https://app.local:8443/",
+	}
+	for target, expected := range tests {
+		u, err := proxyTarget(target)
+		require.NoError(t, err)
+		assert.Equal(t, expected, u.String())
+	}
+
+	_, err := proxyTarget("http://")
+	assert.EqualError(t, err, `invalid proxy target "http://"`)
+}
+
+func TestBuildProxy(t *testing.T) {
+	p, front, stop := newTestProxy(t, nil)
+	defer stop()
+
+	p.OnBuild("")
+	c := getAsync(front.URL + "/users")
+	assertHeld(t, c)
+
+	p.OnResult(&vow.Result{Steps: []*vow.Step{{}}}, true, nil)
+	assert.Equal(t, proxyResponse{http.StatusOK, "hello from /users"}, awaitResponse(t, c))
+
+	// once the app is up requests go straight through
+	assert.Equal(t, proxyResponse{http.StatusOK, "hello from /"}, awaitResponse(t, getAsync(front.URL)))
+}
+
+func TestBuildProxy_Failed(t *testing.T) {
+	p, front, stop := newTestProxy(t, nil)
+	defer stop()
+
+	p.OnBuild("")
+	c := getAsync(front.URL)
+	assertHeld(t, c)
+
+	failed := &vow.Step{
+		Args:     []string{"go", "build"},
+		ExitCode: 2,
+		Err:      errors.New("exit status 2"),
+		Output:   []byte("\x1b[31m./main.go:3:2: undefined: <x>\x1b[0m\n"),
+	}
+	p.OnResult(&vow.Result{Steps: []*vow.Step{failed}}, true, nil)
+
+	resp := awaitResponse(t, c)
+	assert.Equal(t, http.StatusInternalServerError, resp.code)
+	assert.Contains(t, resp.body, "<code>go build</code> exited with code 2")
+	assert.Contains(t, resp.body, "<pre>./main.go:3:2: undefined: &lt;x&gt;</pre>")
+
+	// the page waits for the next build to reload
+	wait := getAsync(front.URL + proxyWaitPath)
+	assertHeld(t, wait)
+	p.OnBuild("")
+	assert.Equal(t, http.StatusNoContent, awaitResponse(t, wait).code)
+}
+
+func TestBuildProxy_Rerun(t *testing.T) {
+	p, front, stop := newTestProxy(t, nil)
+	defer stop()
+
+	failed := &vow.Step{Args: []string{"go", "test"}, ExitCode: 1, Err: errors.New("exit status 1")}
+	p.OnBuild("")
+	p.OnResult(&vow.Result{Steps: []*vow.Step{failed}}, true, nil)
+
+	// the app isn't started by a rerun of the step that failed
+	p.OnBuild("")
+	c := getAsync(front.URL)
+	assertHeld(t, c)
+	p.OnResult(&vow.Result{Steps: []*vow.Step{{Args: []string{"go", "test"}}}}, false, nil)
+	resp := awaitResponse(t, c)
+	assert.Equal(t, http.StatusInternalServerError, resp.code)
+	assert.Contains(t, resp.body, "<code>go test</code> exited with code 1")
+
+	p.OnBuild("")
+	p.OnResult(&vow.Result{Steps: []*vow.Step{{}}}, true, nil)
+	assert.Equal(t, http.StatusOK, awaitResponse(t, getAsync(front.URL)).code)
+}
+
+func TestBuildProxy_Ready(t *testing.T) {
+	p, front, stop := newTestProxy(t, []command{
+		{name: "server", args: []string{"./server"}, ready: regexp.MustCompile(`listening`)},
+	})
+	defer stop()
+
+	server := &vow.Step{Name: "server", Args: []string{"./server"}, Async: true}
+
+	p.OnBuild("")
+	c := getAsync(front.URL)
+	p.OnResult(&vow.Result{Steps: []*vow.Step{server}}, true, nil)
+	assertHeld(t, c)
+
+	p.OnOutput(server, []byte("listening on :3001\n"))
+	assert.Equal(t, http.StatusOK, awaitResponse(t, c).code)
+}
+
+func TestBuildProxy_Close(t *testing.T) {
+	p, front, stop := newTestProxy(t, nil)
+	defer stop()
+
+	p.OnBuild("")
+	c := getAsync(front.URL)
+	assertHeld(t, c)
+
+	require.NoError(t, p.Close())
+	assert.Equal(t, http.StatusServiceUnavailable, awaitResponse(t, c).code)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"regexp"
+
+	"github.com/Tonkpils/snag/vow"
+)
+
+// readiness keeps track of the run commands with a ready pattern that
+// haven't written it yet in the current build, by their label
+type readiness struct {
+	patterns map[string]*regexp.Regexp
+	waiting  map[string]*regexp.Regexp
+}
+
+func newReadiness(runCmds []command) readiness {
+	patterns := make(map[string]*regexp.Regexp)
+	for _, c := range runCmds {
+		if c.ready != nil {
+			patterns[c.label()] = c.ready
+		}
+	}
+	return readiness{patterns: patterns}
+}
+
+// reset waits for every run command again, a build is starting
+func (r *readiness) reset() {
+	r.waiting = make(map[string]*regexp.Regexp, len(r.patterns))
+	for label, re := range r.patterns {
+		r.waiting[label] = re
+	}
+}
+
+// see checks the line written by the step and reports
+// whether it made the step ready
+func (r *readiness) see(s *vow.Step, line []byte) bool {
+	if !s.Async {
+		return false
+	}
+
+	re, ok := r.waiting[s.Label()]
+	if !ok || !re.Match(line) {
+		return false
+	}
+	delete(r.waiting, s.Label())
+	return true
+}
+
+// ready reports whether every run command is ready
+func (r *readiness) ready() bool {
+	return len(r.waiting) == 0
+}
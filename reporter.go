@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Tonkpils/snag/vow"
+	"github.com/fatih/color"
+	"github.com/shiena/ansicolor"
+)
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// reporter is notified of everything snag does while it is watching
+// as well as the progress of every build
+type reporter interface {
+	vow.Reporter
+
+	// OnWatch is called once snag starts watching dir
+	OnWatch(dir string)
+
+	// OnChange is called when a change to path queues a build
+	OnChange(path string)
+
+	// OnBuild is called right before a build starts
+	OnBuild(warning string)
+
+	// OnResult is called once a build has finished unless it was
+	// canceled or the next build started before it could be reported,
+	// with the average durations of the builds before it.
+	// full is set unless only some of its steps ran, like when the
+	// step that failed is rerun.
+	OnResult(res *vow.Result, full bool, avg *averages)
+
+	// OnError is called when something goes wrong outside of a build
+	OnError(err error)
+
+	// OnMessage is called with anything snag has to say
+	// in response to a key being pressed
+	OnMessage(msg string)
+
+	// SetVerbose changes whether the output of
+	// commands that passed is reported
+	SetVerbose(verbose bool)
+}
+
+func newReporter(c config, w io.Writer) reporter {
+	if c.Output == outputJSON {
+		return newJSONReporter(w)
+	}
+
+	// labels and the summary check this when they are written
+	color.NoColor = !c.Color
+	if c.UI {
+		return newUIReporter(w)
+	}
+
+	w = ansicolor.NewAnsiColorWriter(w)
+	r := vow.NewPlainReporter(w)
+	if c.Color {
+		r = vow.NewReporter(w)
+	}
+	r.Verbose = c.Verbose
+	r.Timestamps = c.Timestamps
+	return textReporter{TextReporter: r, w: w, color: c.Color, clear: !c.NoClear}
+}
+
+// textReporter is the default reporter. It writes the status of each
+// command, a summary after every build and clears the screen between
+// them, or writes a separator when it can't clear the screen.
+type textReporter struct {
+	*vow.TextReporter
+
+	w     io.Writer
+	color bool
+	clear bool
+}
+
+func (textReporter) OnWatch(dir string)   {}
+func (textReporter) OnChange(path string) {}
+
+func (r textReporter) OnBuild(warning string) {
+	r.Reset()
+	if r.clear {
+		clearBuffer()
+	} else {
+		fmt.Fprintf(r.w, "\n==== build started at %s ====\n", time.Now().Format("15:04:05"))
+	}
+	if len(warning) > 0 {
+		fmt.Fprintf(r.w, "Deprecation Warnings!\n%s", warning)
+	}
+}
+
+func (r textReporter) OnResult(res *vow.Result, full bool, avg *averages) {
+	// write the summary all at once so output from
+	// async commands doesn't end up in the middle of it
+	var buf bytes.Buffer
+	writeSummary(&buf, res, full, avg, r.color)
+	_, _ = r.w.Write(buf.Bytes())
+}
+
+func (textReporter) OnError(err error) {
+	log.Println("error:", err)
+}
+
+func (r textReporter) OnMessage(msg string) {
+	fmt.Fprintln(r.w, msg)
+}
+
+// handleKey clears the screen when c is pressed, unless
+// the screen isn't cleared between builds either
+func (r textReporter) handleKey(k byte) bool {
+	if k != 'c' {
+		return false
+	}
+	if r.clear {
+		clearBuffer()
+	}
+	return true
+}
+
+// event is a single line written by the jsonReporter
+type event struct {
+	Time      time.Time `json:"time"`
+	Event     string    `json:"event"`
+	Dir       string    `json:"dir,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	Warning   string    `json:"warning,omitempty"`
+	Name      string    `json:"name,omitempty"`
+	Command   string    `json:"command,omitempty"`
+	Pid       int       `json:"pid,omitempty"`
+	ExitCode  *int      `json:"exit_code,omitempty"`
+	Duration  float64   `json:"duration,omitempty"`
+	Average   float64   `json:"average,omitempty"`
+	Passed    *bool     `json:"passed,omitempty"`
+	Cached    bool      `json:"cached,omitempty"`
+	Attempt   int       `json:"attempt,omitempty"`
+	Retrying  bool      `json:"retrying,omitempty"`
+	Allowed   bool      `json:"allowed_failure,omitempty"`
+	LogFile   string    `json:"log_file,omitempty"`
+	Truncated int       `json:"truncated,omitempty"`
+	Output    string    `json:"output,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Message   string    `json:"message,omitempty"`
+}
+
+// jsonReporter writes every event as a line of JSON
+type jsonReporter struct {
+	mtx sync.Mutex
+	enc *json.Encoder
+}
+
+func newJSONReporter(w io.Writer) *jsonReporter {
+	return &jsonReporter{enc: json.NewEncoder(w)}
+}
+
+func (r *jsonReporter) emit(e event) {
+	e.Time = time.Now()
+
+	r.mtx.Lock()
+	// ignoring error since there is not much we can do
+	_ = r.enc.Encode(e)
+	r.mtx.Unlock()
+}
+
+func (r *jsonReporter) OnWatch(dir string) {
+	r.emit(event{Event: "watch_started", Dir: dir})
+}
+
+func (r *jsonReporter) OnChange(path string) {
+	r.emit(event{Event: "file_changed", Path: path})
+}
+
+func (r *jsonReporter) OnBuild(warning string) {
+	r.emit(event{Event: "build_started", Warning: strings.TrimSpace(warning)})
+}
+
+func (r *jsonReporter) OnResult(res *vow.Result, full bool, avg *averages) {
+	passed := res.Passed()
+	e := event{
+		Event:    "build_finished",
+		Passed:   &passed,
+		Duration: res.Duration().Seconds(),
+	}
+	if avg != nil {
+		e.Average = avg.total.Seconds()
+	}
+	r.emit(e)
+}
+
+func (r *jsonReporter) OnError(err error) {
+	r.emit(event{Event: "error", Error: err.Error()})
+}
+
+func (r *jsonReporter) OnMessage(msg string) {
+	r.emit(event{Event: "message", Message: strings.TrimSpace(msg)})
+}
+
+// SetVerbose does nothing, the output of every command is reported
+func (r *jsonReporter) SetVerbose(verbose bool) {}
+
+func (r *jsonReporter) OnStart(s *vow.Step) {
+	name := "step_started"
+	if s.Async {
+		name = "run_started"
+	}
+	r.emit(event{
+		Event:   name,
+		Name:    s.Name,
+		Command: s.Command(),
+		Pid:     s.Pid,
+		Attempt: attempt(s),
+	})
+}
+
+func (r *jsonReporter) OnOutput(s *vow.Step, line []byte) {
+	r.emit(event{
+		Event:   "output",
+		Name:    s.Name,
+		Command: s.Command(),
+		Pid:     s.Pid,
+		Output:  string(line),
+	})
+}
+
+func (r *jsonReporter) OnFinish(s *vow.Step) {
+	name := "step_finished"
+	if s.Async {
+		name = "run_exited"
+	}
+
+	e := event{
+		Event:     name,
+		Name:      s.Name,
+		Command:   s.Command(),
+		Pid:       s.Pid,
+		ExitCode:  &s.ExitCode,
+		Duration:  s.Duration().Seconds(),
+		Cached:    s.Cached,
+		Attempt:   attempt(s),
+		Retrying:  s.Retrying,
+		Allowed:   s.Warned(),
+		LogFile:   s.LogFile,
+		Truncated: s.Truncated,
+		Output:    string(s.Output),
+	}
+	if s.Err != nil {
+		e.Error = s.Err.Error()
+	}
+	r.emit(e)
+}
+
+func (r *jsonReporter) OnCancel() {
+	r.emit(event{Event: "build_canceled"})
+}
+
+// attempt returns the attempt of steps that can be retried
+func attempt(s *vow.Step) int {
+	if s.Retries == 0 {
+		return 0
+	}
+	return s.Attempt
+}
+
+// nopReporter ignores everything, the reporters that follow
+// along embed it and only handle what they care about
+type nopReporter struct{}
+
+func (nopReporter) OnStart(s *vow.Step)                                {}
+func (nopReporter) OnOutput(s *vow.Step, line []byte)                  {}
+func (nopReporter) OnFinish(s *vow.Step)                               {}
+func (nopReporter) OnCancel()                                          {}
+func (nopReporter) OnWatch(dir string)                                 {}
+func (nopReporter) OnChange(path string)                               {}
+func (nopReporter) OnBuild(warning string)                             {}
+func (nopReporter) OnResult(res *vow.Result, full bool, avg *averages) {}
+func (nopReporter) OnError(err error)                                  {}
+func (nopReporter) OnMessage(msg string)                               {}
+func (nopReporter) SetVerbose(verbose bool)                            {}
+
+// teeReporter reports to the reporter snag writes with
+// and to the others that follow along, like the http api
+type teeReporter struct {
+	reporter
+	others []reporter
+}
+
+func (t teeReporter) OnStart(s *vow.Step) {
+	t.reporter.OnStart(s)
+	for _, o := range t.others {
+		o.OnStart(s)
+	}
+}
+
+func (t teeReporter) OnOutput(s *vow.Step, line []byte) {
+	t.reporter.OnOutput(s, line)
+	for _, o := range t.others {
+		o.OnOutput(s, line)
+	}
+}
+
+func (t teeReporter) OnFinish(s *vow.Step) {
+	t.reporter.OnFinish(s)
+	for _, o := range t.others {
+		o.OnFinish(s)
+	}
+}
+
+func (t teeReporter) OnCancel() {
+	t.reporter.OnCancel()
+	for _, o := range t.others {
+		o.OnCancel()
+	}
+}
+
+func (t teeReporter) OnWatch(dir string) {
+	t.reporter.OnWatch(dir)
+	for _, o := range t.others {
+		o.OnWatch(dir)
+	}
+}
+
+func (t teeReporter) OnChange(path string) {
+	t.reporter.OnChange(path)
+	for _, o := range t.others {
+		o.OnChange(path)
+	}
+}
+
+func (t teeReporter) OnBuild(warning string) {
+	t.reporter.OnBuild(warning)
+	for _, o := range t.others {
+		o.OnBuild(warning)
+	}
+}
+
+func (t teeReporter) OnResult(res *vow.Result, full bool, avg *averages) {
+	t.reporter.OnResult(res, full, avg)
+	for _, o := range t.others {
+		o.OnResult(res, full, avg)
+	}
+}
+
+func (t teeReporter) OnError(err error) {
+	t.reporter.OnError(err)
+	for _, o := range t.others {
+		o.OnError(err)
+	}
+}
+
+func (t teeReporter) OnMessage(msg string) {
+	t.reporter.OnMessage(msg)
+	for _, o := range t.others {
+		o.OnMessage(msg)
+	}
+}
+
+// SetVerbose only changes the reporter snag writes with,
+// the others decide what to do with the output themselves
+func (t teeReporter) SetVerbose(verbose bool) {
+	t.reporter.SetVerbose(verbose)
+}
+
+// handleKey leaves the keys to the reporter snag writes with
+func (t teeReporter) handleKey(k byte) bool {
+	h, ok := t.reporter.(keyHandler)
+	return ok && h.handleKey(k)
+}
+
+// Close closes every reporter that needs it
+func (t teeReporter) Close() error {
+	var err error
+	for _, r := range append([]reporter{t.reporter}, t.others...) {
+		if c, ok := r.(io.Closer); ok {
+			if cerr := c.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	return err
+}
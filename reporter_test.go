@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Tonkpils/snag/vow"
+	"github.com/fatih/color"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewReporter(t *testing.T) {
+	var buf bytes.Buffer
+	assert.IsType(t, &jsonReporter{}, newReporter(config{Output: outputJSON}, &buf))
+
+	r := newReporter(config{Output: outputText, Verbose: true, Timestamps: true}, &buf)
+	require.IsType(t, textReporter{}, r)
+	tr := r.(textReporter)
+	assert.True(t, tr.Verbose)
+	assert.True(t, tr.Timestamps)
+
+	r = newReporter(config{}, &buf)
+	assert.IsType(t, textReporter{}, r)
+
+	assert.IsType(t, &uiReporter{}, newReporter(config{UI: true}, &buf))
+}
+
+func TestNewReporter_Color(t *testing.T) {
+	defer func(noColor bool) { color.NoColor = noColor }(color.NoColor)
+
+	var buf bytes.Buffer
+	r := newReporter(config{Color: true}, &buf).(textReporter)
+	r.OnStart(&vow.Step{Args: []string{"go", "test"}})
+	assert.True(t, r.color)
+	assert.Contains(t, buf.String(), "\x1b[")
+
+	buf.Reset()
+	r = newReporter(config{}, &buf).(textReporter)
+	r.OnStart(&vow.Step{Args: []string{"go", "test"}})
+	assert.False(t, r.color)
+	assert.Equal(t, "|In Progress| go test", buf.String())
+}
+
+func TestTextReporter_NoClear(t *testing.T) {
+	defer func(f func()) { clearBuffer = f }(clearBuffer)
+	var cleared bool
+	clearBuffer = func() { cleared = true }
+
+	var buf bytes.Buffer
+	r := newReporter(config{}, &buf)
+	r.OnBuild("")
+	assert.True(t, cleared)
+	assert.Empty(t, buf.String())
+
+	cleared = false
+	assert.True(t, r.(keyHandler).handleKey('c'))
+	assert.True(t, cleared)
+
+	cleared = false
+	r = newReporter(config{NoClear: true}, &buf)
+	r.OnBuild("")
+	assert.True(t, r.(keyHandler).handleKey('c'))
+	assert.False(t, cleared)
+	assert.Regexp(t, `^\n==== build started at \d\d:\d\d:\d\d ====\n$`, buf.String())
+}
+
+func TestJSONReporter(t *testing.T) {
+	var buf bytes.Buffer
+	r := newJSONReporter(&buf)
+
+	start := time.Now()
+	build := &vow.Step{Args: []string{"go", "test"}, Pid: 10, Start: start}
+	run := &vow.Step{Name: "server", Args: []string{"./server"}, Async: true, Pid: 11}
+
+	r.OnWatch("/foo")
+	r.OnChange("/foo/bar.go")
+	r.OnBuild("")
+	r.OnStart(build)
+	build.End = start.Add(2 * time.Second)
+	build.ExitCode = 1
+	build.Err = errors.New("exit status 1")
+	build.Output = []byte("FAIL\n")
+	r.OnFinish(build)
+	r.OnStart(run)
+	r.OnOutput(run, []byte("listening\n"))
+	r.OnCancel()
+	r.OnError(errors.New("oops"))
+	r.OnResult(&vow.Result{Start: start, End: start.Add(time.Second)}, true, nil)
+	r.OnMessage("Paused\n")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 11)
+
+	var events []map[string]interface{}
+	for _, l := range lines {
+		var e map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(l), &e))
+		assert.NotEmpty(t, e["time"])
+		events = append(events, e)
+	}
+
+	names := make([]string, len(events))
+	for i, e := range events {
+		names[i] = e["event"].(string)
+	}
+	assert.Equal(t, []string{
+		"watch_started",
+		"file_changed",
+		"build_started",
+		"step_started",
+		"step_finished",
+		"run_started",
+		"output",
+		"build_canceled",
+		"error",
+		"build_finished",
+		"message",
+	}, names)
+
+	assert.Equal(t, "/foo", events[0]["dir"])
+	assert.Equal(t, "/foo/bar.go", events[1]["path"])
+
+	finished := events[4]
+	assert.Equal(t, "go test", finished["command"])
+	assert.Equal(t, float64(1), finished["exit_code"])
+	assert.Equal(t, float64(2), finished["duration"])
+	assert.Equal(t, "FAIL\n", finished["output"])
+	assert.Equal(t, "exit status 1", finished["error"])
+
+	assert.Equal(t, "server", events[6]["name"])
+	assert.Equal(t, "listening\n", events[6]["output"])
+	assert.Equal(t, "oops", events[8]["error"])
+	assert.Equal(t, true, events[9]["passed"])
+	assert.Equal(t, float64(1), events[9]["duration"])
+	assert.Equal(t, "Paused", events[10]["message"])
+}
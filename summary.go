@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/Tonkpils/snag/vow"
+	"github.com/fatih/color"
+)
+
+// statusWidth fits the longest status in the summary
+const statusWidth = len("Canceled")
+
+var (
+	summaryRed    = color.New(color.FgRed).SprintFunc()
+	summaryGreen  = color.New(color.FgGreen).SprintFunc()
+	summaryYellow = color.New(color.FgYellow).SprintFunc()
+)
+
+// writeSummary writes a table with the status and duration of every
+// step in the result, compared to the averages of recent builds if any.
+// The total is only compared for a full build, not a rerun of some steps.
+func writeSummary(w io.Writer, res *vow.Result, full bool, avg *averages, colored bool) {
+	var width int
+	for _, s := range res.Steps {
+		if l := len(s.Label()); l > width {
+			width = l
+		}
+	}
+
+	fmt.Fprintln(w)
+	for _, s := range res.Steps {
+		status, paint := stepStatus(s)
+		status += strings.Repeat(" ", statusWidth-len(status))
+		if colored {
+			status = paint(status)
+		}
+
+		var dur, change string
+		if !s.Start.IsZero() && !s.Running() && !s.Cached {
+			dur = formatDuration(s.Duration())
+			if s.Passed() {
+				change = trend(s.Duration(), avg.step(s))
+			}
+		}
+
+		line := fmt.Sprintf("%s  %-*s  %8s", status, width, s.Label(), dur)
+		if change != "" {
+			line += "  " + paintTrend(change, colored)
+		}
+		switch {
+		case s.Signal != "":
+			line += "  signal: " + s.Signal
+		case s.ExitCode > 0:
+			line += fmt.Sprintf("  exit code %d", s.ExitCode)
+		}
+		switch {
+		case !s.Retried():
+		case s.Passed():
+			line += fmt.Sprintf("  passed on attempt %d", s.Attempt)
+		default:
+			line += fmt.Sprintf("  after %d attempts", s.Attempt)
+		}
+		fmt.Fprintln(w, strings.TrimRight(line, " "))
+	}
+	total := fmt.Sprintf("%-*s  %-*s  %8s", statusWidth, "", width, "total", formatDuration(res.Duration()))
+	if avg != nil && full && res.Passed() {
+		if change := trend(res.Duration(), avg.total); change != "" {
+			total += "  " + paintTrend(change, colored)
+		}
+	}
+	if res.TimedOut() {
+		total += "  timed out"
+	}
+	fmt.Fprintln(w, total)
+}
+
+// paintTrend shows slowdowns in red and speedups in green
+func paintTrend(change string, colored bool) string {
+	switch {
+	case !colored:
+		return change
+	case change[0] == '+':
+		return summaryRed(change)
+	}
+	return summaryGreen(change)
+}
+
+// stepStatus returns the status of a step and the color it is shown in
+func stepStatus(s *vow.Step) (string, func(...interface{}) string) {
+	switch {
+	case s.Canceled:
+		return "Canceled", summaryYellow
+	case s.Skipped:
+		return "Skipped", summaryYellow
+	case s.Running():
+		return "Running", summaryYellow
+	case s.Warned():
+		return "Warning", summaryYellow
+	case s.Err != nil:
+		return "Failed", summaryRed
+	case s.Cached:
+		return "Cached", summaryGreen
+	}
+	return "Passed", summaryGreen
+}
+
+// formatDuration rounds d to something a person wants to read
+func formatDuration(d time.Duration) string {
+	if d < time.Second {
+		return fmt.Sprintf("%dms", d/time.Millisecond)
+	}
+	return fmt.Sprintf("%.1fs", d.Seconds())
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Tonkpils/snag/vow"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteSummary(t *testing.T) {
+	start := time.Now()
+	res := &vow.Result{
+		Start: start,
+		End:   start.Add(3500 * time.Millisecond),
+		Steps: []*vow.Step{
+			{Args: []string{"go", "build"}, Start: start, End: start.Add(800 * time.Millisecond)},
+			{
+				Args:     []string{"go", "test", "./..."},
+				Start:    start,
+				End:      start.Add(2700 * time.Millisecond),
+				Err:      errors.New("exit status 1"),
+				ExitCode: 1,
+			},
+			{Args: []string{"go", "vet"}, Skipped: true},
+			{
+				Args:         []string{"golint"},
+				Start:        start,
+				End:          start.Add(100 * time.Millisecond),
+				Err:          errors.New("exit status 1"),
+				ExitCode:     1,
+				AllowFailure: true,
+			},
+			{Args: []string{"protoc"}, Cached: true, Start: start, End: start},
+			{Args: []string{"./e2e"}, Attempt: 2, Retries: 2, Start: start, End: start.Add(time.Second)},
+			{Name: "server", Args: []string{"./server"}, Async: true, Start: start},
+		},
+	}
+
+	var buf bytes.Buffer
+	writeSummary(&buf, res, true, nil, false)
+
+	e := "\n" +
+		"Passed    go build          800ms\n" +
+		"Failed    go test ./...      2.7s  exit code 1\n" +
+		"Skipped   go vet\n" +
+		"Warning   golint            100ms  exit code 1\n" +
+		"Cached    protoc\n" +
+		"Passed    ./e2e              1.0s  passed on attempt 2\n" +
+		"Running   server\n" +
+		"          total              3.5s\n"
+	assert.Equal(t, e, buf.String())
+}
+
+func TestWriteSummary_Trend(t *testing.T) {
+	start := time.Now()
+	res := &vow.Result{
+		Start: start,
+		End:   start.Add(15 * time.Second),
+		Steps: []*vow.Step{
+			{Args: []string{"go", "build"}, Start: start, End: start.Add(800 * time.Millisecond)},
+			{Args: []string{"go", "test"}, Start: start, End: start.Add(14200 * time.Millisecond)},
+		},
+	}
+	avg := &averages{
+		total: 10 * time.Second,
+		steps: map[string]time.Duration{
+			"go build": 2 * time.Second,
+			"go test":  8875 * time.Millisecond,
+		},
+	}
+
+	var buf bytes.Buffer
+	writeSummary(&buf, res, true, avg, false)
+
+	e := "\n" +
+		"Passed    go build     800ms  -60% vs avg\n" +
+		"Passed    go test      14.2s  +60% vs avg\n" +
+		"          total        15.0s  +50% vs avg\n"
+	assert.Equal(t, e, buf.String())
+
+	// a rerun of one step is not compared with whole builds
+	res.Steps = res.Steps[1:]
+	buf.Reset()
+	writeSummary(&buf, res, false, avg, false)
+
+	e = "\n" +
+		"Passed    go test     14.2s  +60% vs avg\n" +
+		"          total       15.0s\n"
+	assert.Equal(t, e, buf.String())
+}
+
+func TestWriteSummary_TimedOut(t *testing.T) {
+	start := time.Now()
+	res := &vow.Result{
+		Start: start,
+		End:   start.Add(time.Minute),
+		Err:   context.DeadlineExceeded,
+		Steps: []*vow.Step{
+			{Args: []string{"go", "test"}, Start: start, End: start.Add(time.Minute), ExitCode: -1, Signal: "terminated", Canceled: true},
+		},
+	}
+
+	var buf bytes.Buffer
+	writeSummary(&buf, res, true, nil, false)
+
+	e := "\n" +
+		"Canceled  go test     60.0s  signal: terminated\n" +
+		"          total       60.0s  timed out\n"
+	assert.Equal(t, e, buf.String())
+}
+
+func TestFormatDuration(t *testing.T) {
+	assert.Equal(t, "0ms", formatDuration(0))
+	assert.Equal(t, "999ms", formatDuration(999*time.Millisecond))
+	assert.Equal(t, "1.0s", formatDuration(time.Second))
+	assert.Equal(t, "62.3s", formatDuration(62345*time.Millisecond))
+}
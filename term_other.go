@@ -0,0 +1,22 @@
+// +build !linux,!darwin
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+var errNoTerminal = errors.New("terminals are not supported on this platform")
+
+// makeRaw is not supported, so the keys can't be used
+func makeRaw(f *os.File) (func(), error) {
+	return nil, errNoTerminal
+}
+
+func terminalSize(f *os.File) (int, int, error) {
+	return 0, 0, errNoTerminal
+}
+
+// notifyResize does nothing, there is no way to tell
+func notifyResize(c chan<- os.Signal) {}
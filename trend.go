@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Tonkpils/snag/vow"
+)
+
+const (
+	// trendBuilds is how many of the last builds
+	// the durations of a build are compared with
+	trendBuilds = 10
+
+	// trendPercent and trendMin are how much slower or faster than
+	// usual a step needs to be for the difference to be pointed out
+	trendPercent = 10
+	trendMin     = 100 * time.Millisecond
+)
+
+// averages are the average durations of recent builds
+// and of the steps in them, by the label of the step
+type averages struct {
+	total time.Duration
+	steps map[string]time.Duration
+}
+
+// step returns the average duration of the step, or 0 when there is none
+func (a *averages) step(s *vow.Step) time.Duration {
+	if a == nil {
+		return 0
+	}
+	return a.steps[s.Label()]
+}
+
+// timings keeps how long the steps of the last builds took
+type timings struct {
+	mtx   sync.Mutex
+	total []time.Duration
+	steps map[string][]time.Duration
+}
+
+func newTimings() *timings {
+	return &timings{steps: make(map[string][]time.Duration)}
+}
+
+// add records the durations of the steps that passed, the total is only
+// recorded for full builds that passed since it is not comparable otherwise
+func (t *timings) add(res *vow.Result, full bool) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if full && res.Passed() {
+		t.total = appendDuration(t.total, res.Duration())
+	}
+	for _, s := range res.Steps {
+		if !s.Passed() || s.Cached || s.Async {
+			continue
+		}
+		t.steps[s.Label()] = appendDuration(t.steps[s.Label()], s.Duration())
+	}
+}
+
+// averages returns the averages of the builds recorded so far
+func (t *timings) averages() *averages {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	avg := &averages{
+		total: average(t.total),
+		steps: make(map[string]time.Duration, len(t.steps)),
+	}
+	for label, ds := range t.steps {
+		avg.steps[label] = average(ds)
+	}
+	return avg
+}
+
+// appendDuration adds d to the last durations, dropping
+// the oldest once there are more than trendBuilds
+func appendDuration(ds []time.Duration, d time.Duration) []time.Duration {
+	ds = append(ds, d)
+	if len(ds) > trendBuilds {
+		ds = ds[len(ds)-trendBuilds:]
+	}
+	return ds
+}
+
+func average(ds []time.Duration) time.Duration {
+	if len(ds) == 0 {
+		return 0
+	}
+
+	var sum time.Duration
+	for _, d := range ds {
+		sum += d
+	}
+	return sum / time.Duration(len(ds))
+}
+
+// trend describes how d compares to the average, it is empty
+// when there is no average or d is about the same as usual
+func trend(d, avg time.Duration) string {
+	if avg <= 0 {
+		return ""
+	}
+
+	diff := d - avg
+	if diff > -trendMin && diff < trendMin {
+		return ""
+	}
+	percent := int64(diff) * 100 / int64(avg)
+	if percent > -trendPercent && percent < trendPercent {
+		return ""
+	}
+	return fmt.Sprintf("%+d%% vs avg", percent)
+}
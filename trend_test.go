@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Tonkpils/snag/vow"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimings(t *testing.T) {
+	start := time.Now()
+	result := func(test time.Duration, err error) *vow.Result {
+		return &vow.Result{
+			Start: start,
+			End:   start.Add(time.Second + test),
+			Steps: []*vow.Step{
+				{Args: []string{"go", "build"}, Start: start, End: start.Add(time.Second)},
+				{Args: []string{"go", "test"}, Start: start, End: start.Add(test), Err: err},
+				{Args: []string{"protoc"}, Cached: true, Start: start, End: start},
+			},
+		}
+	}
+
+	tm := newTimings()
+	avg := tm.averages()
+	assert.Equal(t, time.Duration(0), avg.total)
+	assert.Empty(t, avg.steps)
+
+	tm.add(result(2*time.Second, nil), true)
+	tm.add(result(4*time.Second, nil), true)
+	// failures and partial builds don't count towards the total
+	tm.add(result(10*time.Second, errors.New("exit status 1")), true)
+	tm.add(result(6*time.Second, nil), false)
+
+	avg = tm.averages()
+	assert.Equal(t, 4*time.Second, avg.total)
+	assert.Equal(t, time.Second, avg.step(&vow.Step{Args: []string{"go", "build"}}))
+	assert.Equal(t, 4*time.Second, avg.step(&vow.Step{Args: []string{"go", "test"}}))
+	assert.Equal(t, time.Duration(0), avg.step(&vow.Step{Args: []string{"protoc"}}))
+
+	for i := 0; i < trendBuilds; i++ {
+		tm.add(result(time.Second, nil), true)
+	}
+	avg = tm.averages()
+	assert.Equal(t, 2*time.Second, avg.total)
+	assert.Equal(t, time.Second, avg.step(&vow.Step{Args: []string{"go", "test"}}))
+}
+
+func TestTrend(t *testing.T) {
+	tests := []struct {
+		D, Avg time.Duration
+		Trend  string
+	}{
+		{D: 14200 * time.Millisecond, Avg: 8875 * time.Millisecond, Trend: "+60% vs avg"},
+		{D: 5 * time.Second, Avg: 10 * time.Second, Trend: "-50% vs avg"},
+		{D: 10500 * time.Millisecond, Avg: 10 * time.Second},
+		{D: 20 * time.Millisecond, Avg: 10 * time.Millisecond},
+		{D: time.Second},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.Trend, trend(test.D, test.Avg), "%s vs %s", test.D, test.Avg)
+	}
+}
@@ -0,0 +1,651 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Tonkpils/snag/vow"
+	"github.com/fatih/color"
+)
+
+const (
+	// uiMaxLines is how many lines of output are kept for each pane
+	uiMaxLines = 2000
+
+	// uiHistory is how many of the last builds are listed
+	uiHistory = 10
+
+	// uiChanges is how many of the changed files are listed
+	uiChanges = 5
+
+	// uiRefresh is how often the dashboard is redrawn while
+	// something changes, running steps show their duration
+	uiRefresh = 100 * time.Millisecond
+)
+
+const uiKeysHelp = `Dashboard keys:
+  j, down   select the next step
+  k, up     select the previous step
+  u, pgup   scroll the output up
+  d, pgdn   scroll the output down
+  c         redraw the screen`
+
+var (
+	uiBold    = color.New(color.Bold).SprintFunc()
+	uiInverse = color.New(color.ReverseVideo).SprintFunc()
+)
+
+// escapePattern matches the terminal escape sequences that
+// could be written by commands running in a terminal
+var escapePattern = regexp.MustCompile("\x1b(\\[[0-9;?]*[ -/]*[@-~]|\\][^\x07\x1b]*(\x07|\x1b\\\\)|[@-Z\\\\-_])")
+
+// pane is a step or a run process shown on the dashboard
+type pane struct {
+	// step is the record handed out by vow, it is only used to
+	// tell which pane its output goes to, rec is a copy of it
+	step *vow.Step
+	rec  vow.Step
+
+	lines []string
+
+	// scroll is how many lines the output is scrolled up
+	scroll int
+}
+
+// buildEntry is a finished build listed in the history
+type buildEntry struct {
+	n   int
+	res *vow.Result
+}
+
+// uiReporter shows a full-screen dashboard with the steps of the
+// current build, the output of each of them, the files that changed
+// and the last few builds instead of scrolling output
+type uiReporter struct {
+	w    io.Writer
+	size func() (int, int)
+
+	// build is the number of the current build, it continues from
+	// the builds before snag started and started is set once it has
+	mtx      sync.Mutex
+	build    int
+	started  bool
+	running  bool
+	start    time.Time
+	last     *vow.Result
+	avg      *averages
+	steps    []*pane
+	procs    []*pane
+	selected int
+	follow   bool
+	changes  []string
+	queued   []string
+	history  []buildEntry
+	message  string
+	help     bool
+	escape   []byte
+	dirty    bool
+	clear    bool
+
+	startOnce sync.Once
+	closeOnce sync.Once
+	done      chan struct{}
+	stopped   chan struct{}
+}
+
+func newUIReporter(w io.Writer) *uiReporter {
+	return &uiReporter{
+		w: w,
+		size: func() (int, int) {
+			w, h, err := terminalSize(os.Stdout)
+			if err != nil || w == 0 || h == 0 {
+				return 80, 24
+			}
+			return w, h
+		},
+		follow:  true,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+// OnWatch takes over the screen
+func (u *uiReporter) OnWatch(dir string) {
+	u.startOnce.Do(func() {
+		// use the alternate screen so the scrollback is left alone
+		_, _ = io.WriteString(u.w, "\033[?1049h\033[?25l")
+		go u.loop()
+	})
+}
+
+// Close gives the screen back the way it was
+func (u *uiReporter) Close() error {
+	u.closeOnce.Do(func() {
+		close(u.done)
+
+		// the screen was never taken over
+		started := true
+		u.startOnce.Do(func() { started = false })
+		if !started {
+			return
+		}
+		<-u.stopped
+		_, _ = io.WriteString(u.w, "\033[?25h\033[?1049l")
+	})
+	return nil
+}
+
+func (u *uiReporter) loop() {
+	defer close(u.stopped)
+
+	resize := make(chan os.Signal, 1)
+	notifyResize(resize)
+	ticker := time.NewTicker(uiRefresh)
+	defer ticker.Stop()
+
+	u.redraw()
+	for {
+		select {
+		case <-u.done:
+			return
+		case <-resize:
+			u.redraw()
+		case <-ticker.C:
+			u.draw()
+		}
+	}
+}
+
+// redraw draws the whole screen again
+func (u *uiReporter) redraw() {
+	u.mtx.Lock()
+	u.clear, u.dirty = true, true
+	u.mtx.Unlock()
+	u.draw()
+}
+
+// draw writes the dashboard if anything changed
+func (u *uiReporter) draw() {
+	width, height := u.size()
+
+	u.mtx.Lock()
+	if !u.dirty && !u.running {
+		u.mtx.Unlock()
+		return
+	}
+	frame := u.render(width, height)
+	if u.clear {
+		frame = append([]byte("\033[2J"), frame...)
+	}
+	u.dirty, u.clear = false, false
+	u.mtx.Unlock()
+
+	_, _ = u.w.Write(frame)
+}
+
+func (u *uiReporter) OnChange(path string) {
+	u.mtx.Lock()
+	u.queued = append(u.queued, path)
+	u.dirty = true
+	u.mtx.Unlock()
+}
+
+func (u *uiReporter) OnBuild(warning string) {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+
+	u.build++
+	u.started = true
+	u.running = true
+	u.start = time.Now()
+	u.last = nil
+	u.steps, u.procs = nil, nil
+	u.selected, u.follow = 0, true
+	u.changes, u.queued = u.queued, nil
+	u.message = strings.TrimSpace(warning)
+	u.dirty = true
+}
+
+func (u *uiReporter) OnResult(res *vow.Result, full bool, avg *averages) {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+
+	u.running = false
+	u.last = res
+	u.avg = avg
+	if !full {
+		// a rerun of some steps isn't compared with whole builds
+		u.avg = nil
+	}
+	u.history = append(u.history, buildEntry{n: u.build, res: res})
+	if len(u.history) > uiHistory {
+		u.history = u.history[1:]
+	}
+
+	// steps that never started still get listed
+	for _, s := range res.Steps {
+		if s.Start.IsZero() && !s.Async {
+			u.steps = append(u.steps, &pane{rec: *s})
+		}
+	}
+
+	// show what went wrong
+	if f := res.Failed(); f != nil && u.follow {
+		for i, p := range u.steps {
+			if p.rec.Label() == f.Label() && p.rec.Err != nil {
+				u.selected = i
+				break
+			}
+		}
+	}
+	u.dirty = true
+}
+
+func (u *uiReporter) OnError(err error) {
+	u.OnMessage("error: " + err.Error())
+}
+
+func (u *uiReporter) OnMessage(msg string) {
+	u.mtx.Lock()
+	u.message = strings.Join(strings.Fields(msg), " ")
+	u.dirty = true
+	u.mtx.Unlock()
+}
+
+// SetVerbose does nothing, the output of every step can be looked at
+func (u *uiReporter) SetVerbose(verbose bool) {}
+
+func (u *uiReporter) OnStart(s *vow.Step) {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+
+	p := u.pane(s)
+	if p == nil {
+		p = &pane{step: s}
+		if s.Async {
+			u.procs = append(u.procs, p)
+		} else {
+			u.steps = append(u.steps, p)
+		}
+	}
+	p.rec = *s
+
+	if u.follow && !s.Async {
+		u.selected = len(u.steps) - 1
+	}
+	u.dirty = true
+}
+
+func (u *uiReporter) OnOutput(s *vow.Step, line []byte) {
+	u.mtx.Lock()
+	if p := u.pane(s); p != nil {
+		p.add(string(line))
+		u.dirty = true
+	}
+	u.mtx.Unlock()
+}
+
+func (u *uiReporter) OnFinish(s *vow.Step) {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+
+	p := u.pane(s)
+	if p == nil {
+		return
+	}
+	p.rec = *s
+
+	// the output of steps that were not streamed comes all at once
+	if !s.Streamed && !s.Async && len(s.Output) > 0 {
+		for _, l := range strings.SplitAfter(string(s.Output), "\n") {
+			if l != "" {
+				p.add(l)
+			}
+		}
+	}
+	u.dirty = true
+}
+
+func (u *uiReporter) OnCancel() {
+	u.mtx.Lock()
+	u.running = false
+	u.dirty = true
+	u.mtx.Unlock()
+}
+
+// pane returns the pane of the step, if it has one
+func (u *uiReporter) pane(s *vow.Step) *pane {
+	for _, p := range u.panes() {
+		if p.step == s {
+			return p
+		}
+	}
+	return nil
+}
+
+// panes returns the panes in the order they are listed
+func (u *uiReporter) panes() []*pane {
+	all := make([]*pane, 0, len(u.steps)+len(u.procs))
+	all = append(all, u.steps...)
+	return append(all, u.procs...)
+}
+
+func (p *pane) add(line string) {
+	p.lines = append(p.lines, cleanLine(line))
+	if len(p.lines) > uiMaxLines {
+		p.lines = p.lines[len(p.lines)-uiMaxLines:]
+	}
+	if p.scroll > 0 {
+		// keep looking at the same lines
+		p.scroll++
+	}
+	if max := len(p.lines) - 1; p.scroll > max {
+		p.scroll = max
+	}
+}
+
+// handleKey moves around the dashboard, it reports whether
+// the key was used so that it isn't handled again
+func (u *uiReporter) handleKey(k byte) bool {
+	u.mtx.Lock()
+
+	// arrows and page keys are escape sequences
+	if k == '\033' || len(u.escape) > 0 {
+		u.escape = append(u.escape, k)
+		seq := string(u.escape)
+		switch {
+		case seq == "\033" || seq == "\033[" || seq == "\033[5" || seq == "\033[6":
+			u.mtx.Unlock()
+			return true
+		case seq == "\033[B":
+			k = 'j'
+		case seq == "\033[A":
+			k = 'k'
+		case seq == "\033[5~":
+			k = 'u'
+		case seq == "\033[6~":
+			k = 'd'
+		default:
+			k = 0
+		}
+		u.escape = nil
+	}
+
+	_, height := u.size()
+	page := height / 2
+	handled := true
+	switch k {
+	case 0:
+	case 'j':
+		if u.selected < len(u.panes())-1 {
+			u.selected++
+		}
+		u.follow, u.help = false, false
+	case 'k':
+		if u.selected > 0 {
+			u.selected--
+		}
+		u.follow, u.help = false, false
+	case 'u', 'd':
+		if p := u.current(); p != nil {
+			if k == 'u' {
+				p.scroll += page
+			} else {
+				p.scroll -= page
+			}
+			if max := len(p.lines) - 1; p.scroll > max {
+				p.scroll = max
+			}
+			if p.scroll < 0 {
+				p.scroll = 0
+			}
+		}
+	case '?':
+		u.help = !u.help
+	case 'c':
+		u.clear = true
+	default:
+		handled = false
+	}
+	u.dirty = true
+	u.mtx.Unlock()
+	return handled
+}
+
+// current returns the selected pane
+func (u *uiReporter) current() *pane {
+	panes := u.panes()
+	if u.selected < 0 || u.selected >= len(panes) {
+		return nil
+	}
+	return panes[u.selected]
+}
+
+// render returns a frame of the dashboard for a screen
+// of the given size, u.mtx must be held
+func (u *uiReporter) render(width, height int) []byte {
+	if width < 50 {
+		width = 50
+	}
+	if height < 8 {
+		height = 8
+	}
+
+	leftWidth := width / 3
+	if leftWidth < 34 {
+		leftWidth = 34
+	}
+	if leftWidth > 50 {
+		leftWidth = 50
+	}
+	rightWidth := width - leftWidth - 3
+	rows := height - 2
+
+	left := u.renderLeft(leftWidth)
+	right := u.renderRight(rightWidth, rows)
+
+	var buf bytes.Buffer
+	buf.WriteString("\033[H")
+	buf.WriteString(uiInverse(fit(u.header(), width)))
+	buf.WriteString("\033[K\n")
+	for i := 0; i < rows; i++ {
+		l := strings.Repeat(" ", leftWidth)
+		if i < len(left) {
+			l = left[i]
+		}
+		var r string
+		if i < len(right) {
+			r = right[i]
+		}
+		buf.WriteString(l + " │ " + r + "\033[K\n")
+	}
+
+	footer := u.message
+	if footer == "" {
+		footer = "j/k select  u/d scroll  r rebuild  f rerun failed  p pause  ? keys  q quit"
+	}
+	buf.WriteString(fit(footer, width))
+	buf.WriteString("\033[K")
+	return buf.Bytes()
+}
+
+func (u *uiReporter) header() string {
+	h := " snag"
+	if !u.started {
+		return h + "  waiting for the first build"
+	}
+	h += fmt.Sprintf("  build #%d  ", u.build)
+	switch {
+	case u.running:
+		h += "Running  " + formatDuration(time.Since(u.start))
+	case u.last == nil:
+		h += "Canceled"
+	case u.last.Passed():
+		h += "Passed  " + formatDuration(u.last.Duration())
+		if u.avg != nil {
+			if change := trend(u.last.Duration(), u.avg.total); change != "" {
+				h += "  " + change
+			}
+		}
+	default:
+		h += "Failed  " + formatDuration(u.last.Duration())
+	}
+	if n := len(u.changes); n > 0 {
+		h += fmt.Sprintf("  %d changed", n)
+	}
+	return h
+}
+
+// renderLeft returns the lines listing the steps, the
+// changed files and the history, each width wide
+func (u *uiReporter) renderLeft(width int) []string {
+	var lines []string
+	heading := func(s string) {
+		if len(lines) > 0 {
+			lines = append(lines, strings.Repeat(" ", width))
+		}
+		lines = append(lines, uiBold(fit(s, width)))
+	}
+
+	heading("Steps")
+	for i, p := range u.steps {
+		lines = append(lines, u.stepLine(i, p, width))
+	}
+	if len(u.procs) > 0 {
+		heading("Processes")
+		for i, p := range u.procs {
+			lines = append(lines, u.stepLine(len(u.steps)+i, p, width))
+		}
+	}
+
+	if len(u.changes) > 0 {
+		heading("Changed files")
+		changes := u.changes
+		if len(changes) > uiChanges {
+			changes = changes[len(changes)-uiChanges:]
+		}
+		for _, c := range changes {
+			lines = append(lines, fit("  "+c, width))
+		}
+		if more := len(u.changes) - len(changes); more > 0 {
+			lines = append(lines, fit(fmt.Sprintf("  and %d more", more), width))
+		}
+	}
+
+	if len(u.history) > 0 {
+		heading("Recent builds")
+		for i := len(u.history) - 1; i >= 0; i-- {
+			e := u.history[i]
+			status, paint := "Passed", summaryGreen
+			if !e.res.Passed() {
+				status, paint = "Failed", summaryRed
+			}
+			info := fmt.Sprintf(" %s %s", e.res.End.Format("15:04:05"), formatDuration(e.res.Duration()))
+			num := fmt.Sprintf("  #%-3d ", e.n)
+			lines = append(lines, num+paint(fmt.Sprintf("%-*s", statusWidth, status))+fit(info, width-len(num)-statusWidth))
+		}
+	}
+	return lines
+}
+
+// stepLine returns the line listing a step with its status and duration
+func (u *uiReporter) stepLine(i int, p *pane, width int) string {
+	marker := "  "
+	if i == u.selected && !u.help {
+		marker = "> "
+	}
+
+	status, paint := stepStatus(&p.rec)
+	var dur string
+	switch {
+	case p.rec.Start.IsZero() || p.rec.Cached:
+	case p.rec.Running():
+		dur = formatDuration(time.Since(p.rec.Start))
+	default:
+		dur = formatDuration(p.rec.Duration())
+	}
+
+	label := fit(p.rec.Label(), width-len(marker)-statusWidth-8)
+	if i == u.selected && !u.help {
+		label = uiBold(label)
+	}
+	return marker + paint(fmt.Sprintf("%-*s", statusWidth, status)) + " " + label + fmt.Sprintf(" %6s", dur)
+}
+
+// renderRight returns the lines of the output pane
+// of the selected step, or the help
+func (u *uiReporter) renderRight(width, rows int) []string {
+	if u.help {
+		lines := []string{uiInverse(fit(" Keys", width))}
+		for _, l := range strings.Split(keysHelp+"\n\n"+uiKeysHelp, "\n") {
+			lines = append(lines, fit(l, width))
+		}
+		return lines
+	}
+
+	p := u.current()
+	if p == nil {
+		return nil
+	}
+
+	title := " " + p.rec.Command()
+	if p.scroll > 0 {
+		title += fmt.Sprintf("  (%d lines below)", p.scroll)
+	}
+	lines := []string{uiInverse(fit(title, width))}
+
+	end := len(p.lines) - p.scroll
+	start := end - (rows - 1)
+	if start < 0 {
+		start = 0
+	}
+	for _, l := range p.lines[start:end] {
+		lines = append(lines, truncate(l, width))
+	}
+	return lines
+}
+
+// cleanLine makes a line of output safe to put on the dashboard
+func cleanLine(line string) string {
+	line = strings.TrimRight(line, "\r\n")
+
+	// only the last of the lines written over each other is seen
+	if i := strings.LastIndex(line, "\r"); i != -1 {
+		line = line[i+1:]
+	}
+	line = escapePattern.ReplaceAllString(line, "")
+	line = strings.Replace(line, "\t", "    ", -1)
+	return strings.Map(func(r rune) rune {
+		if r < ' ' {
+			return -1
+		}
+		return r
+	}, line)
+}
+
+// truncate cuts s down to width characters
+func truncate(s string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	return string([]rune(s)[:width-1]) + "…"
+}
+
+// fit truncates or pads s to exactly width characters
+func fit(s string, width int) string {
+	s = truncate(s, width)
+	if pad := width - utf8.RuneCountInString(s); pad > 0 {
+		s += strings.Repeat(" ", pad)
+	}
+	return s
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Tonkpils/snag/vow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestUI() (*uiReporter, *bytes.Buffer) {
+	var buf bytes.Buffer
+	u := newUIReporter(&buf)
+	u.size = func() (int, int) { return 100, 20 }
+	return u, &buf
+}
+
+func TestUIReporter(t *testing.T) {
+	u, _ := newTestUI()
+
+	start := time.Now()
+	build := &vow.Step{Args: []string{"go", "build"}, Streamed: true, Start: start}
+	test := &vow.Step{Args: []string{"go", "test"}, Streamed: true, Start: start}
+	server := &vow.Step{Name: "server", Args: []string{"./server"}, Async: true, Start: start}
+
+	u.OnChange("main.go")
+	u.OnBuild("")
+	u.OnStart(build)
+	build.End = start.Add(300 * time.Millisecond)
+	u.OnFinish(build)
+	u.OnStart(test)
+	u.OnOutput(test, []byte("\x1b[31m--- FAIL: TestFoo\x1b[0m\n"))
+	u.OnOutput(test, []byte("50%\r100%\n"))
+	test.End = start.Add(time.Second)
+	test.Err = errors.New("exit status 1")
+	u.OnFinish(test)
+	u.OnStart(server)
+	u.OnOutput(server, []byte("listening\n"))
+
+	skipped := vow.Step{Args: []string{"go", "install"}, Skipped: true}
+	u.OnResult(&vow.Result{
+		Steps: []*vow.Step{build, test, &skipped, server},
+		Start: start,
+		End:   start.Add(time.Second),
+	}, true, nil)
+
+	frame := string(u.render(100, 20))
+	assert.Contains(t, frame, "build #1  Failed  1.0s  1 changed")
+	assert.Contains(t, frame, "Passed   go build")
+	assert.Contains(t, frame, "Failed   go test")
+	assert.Contains(t, frame, "Skipped  go install")
+	assert.Contains(t, frame, "Processes")
+	assert.Contains(t, frame, "Running  server")
+	assert.Contains(t, frame, "Changed files")
+	assert.Contains(t, frame, "main.go")
+	assert.Contains(t, frame, "#1   Failed")
+
+	// the failed step is shown
+	assert.Contains(t, frame, "> Failed   go test")
+	assert.Contains(t, frame, "--- FAIL: TestFoo")
+	assert.Contains(t, frame, "100%")
+	assert.NotContains(t, frame, "50%")
+	assert.NotContains(t, frame, "listening")
+
+	u.handleKey('j')
+	u.handleKey('j')
+	frame = string(u.render(100, 20))
+	assert.Contains(t, frame, "> Running  server")
+	assert.Contains(t, frame, "listening")
+
+	// a new build starts with a clean slate
+	u.OnBuild("")
+	frame = string(u.render(100, 20))
+	assert.Contains(t, frame, "build #2  Running")
+	assert.NotContains(t, frame, "go test")
+	assert.NotContains(t, frame, "Changed files")
+	assert.Contains(t, frame, "#1   Failed")
+}
+
+func TestUIReporterScroll(t *testing.T) {
+	u, _ := newTestUI()
+
+	s := &vow.Step{Args: []string{"go", "test"}, Start: time.Now()}
+	u.OnBuild("")
+	u.OnStart(s)
+	for i := 0; i < 100; i++ {
+		u.OnOutput(s, []byte(fmt.Sprintf("line %d\n", i)))
+	}
+
+	frame := string(u.render(100, 20))
+	assert.Contains(t, frame, "line 99")
+	assert.NotContains(t, frame, "line 80\n")
+
+	// page up, arrow keys are escape sequences
+	for _, k := range []byte("\x1b[5~") {
+		assert.True(t, u.handleKey(k))
+	}
+	frame = string(u.render(100, 20))
+	assert.Contains(t, frame, "(10 lines below)")
+	assert.Contains(t, frame, "line 89")
+	assert.NotContains(t, frame, "line 99")
+
+	// new output doesn't move what is being looked at
+	u.OnOutput(s, []byte("line 100\n"))
+	assert.Contains(t, string(u.render(100, 20)), "(11 lines below)")
+
+	u.handleKey('d')
+	u.handleKey('d')
+	frame = string(u.render(100, 20))
+	assert.NotContains(t, frame, "lines below")
+	assert.Contains(t, frame, "line 100")
+}
+
+func TestUIReporterKeys(t *testing.T) {
+	u, _ := newTestUI()
+
+	assert.True(t, u.handleKey('?'))
+	assert.Contains(t, string(u.render(100, 20)), "rerun the step that failed")
+	assert.True(t, u.handleKey('?'))
+
+	// the rest are left to snag
+	for _, k := range []byte("rpvfq") {
+		assert.False(t, u.handleKey(k), "key %q", k)
+	}
+
+	u.OnMessage("Paused, press p to resume\n")
+	assert.Contains(t, string(u.render(100, 20)), "Paused, press p to resume")
+}
+
+func TestUIReporterScreen(t *testing.T) {
+	u, buf := newTestUI()
+
+	u.OnWatch("/foo")
+	require.NoError(t, u.Close())
+	require.NoError(t, u.Close())
+
+	out := buf.String()
+	assert.True(t, strings.HasPrefix(out, "\033[?1049h"), "the alternate screen was not used")
+	assert.True(t, strings.HasSuffix(out, "\033[?1049l"), "the screen was not given back")
+
+	// nothing is written if the screen was never taken over
+	u, buf = newTestUI()
+	require.NoError(t, u.Close())
+	u.OnWatch("/foo")
+	assert.Empty(t, buf.String())
+}
+
+func TestCleanLine(t *testing.T) {
+	tests := []struct {
+		Line, Clean string
+	}{
+		{"plain\n", "plain"},
+		{"\x1b[1;32mok\x1b[0m\tpkg\r\n", "ok    pkg"},
+		{"10%\r20%\r30%", "30%"},
+		{"\x1b]0;title\x07bell\a", "bell"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.Clean, cleanLine(test.Line))
+	}
+}
+
+func TestFit(t *testing.T) {
+	assert.Equal(t, "abc  ", fit("abc", 5))
+	assert.Equal(t, "abcd…", fit("abcdefgh", 5))
+	assert.Equal(t, "", fit("abc", 0))
+}
+
+func TestNewBuilder_UI(t *testing.T) {
+	wd, tmpDir := tmpDirectory(t)
+	defer os.RemoveAll(tmpDir)
+	chdir(t, tmpDir)
+	defer os.Chdir(wd)
+	require.NoError(t, os.MkdirAll(filepath.Join(logsDir, "56"), 0755))
+
+	b, err := NewBuilder(config{UI: true})
+	require.NoError(t, err)
+	defer b.Close()
+
+	// the builds are numbered like the logs
+	u := b.reporter.(*uiReporter)
+	u.size = func() (int, int) { return 100, 20 }
+	assert.Contains(t, string(u.render(100, 20)), "waiting for the first build")
+	u.OnBuild("")
+	assert.Contains(t, string(u.render(100, 20)), "build #57  Running")
+}
@@ -1,9 +1,35 @@
 package vow
 
-import "github.com/fatih/color"
+import (
+	"hash/fnv"
+
+	"github.com/fatih/color"
+)
 
 var (
 	red    = color.New(color.FgRed).SprintFunc()
 	yellow = color.New(color.FgYellow).SprintFunc()
 	green  = color.New(color.FgGreen).SprintFunc()
 )
+
+// labelColors are the colors used to tell the output
+// of async commands apart from one another
+var labelColors = []color.Attribute{
+	color.FgCyan,
+	color.FgMagenta,
+	color.FgBlue,
+	color.FgHiGreen,
+	color.FgHiYellow,
+	color.FgHiCyan,
+	color.FgHiMagenta,
+	color.FgHiBlue,
+}
+
+// labelColor picks a color for the given label. The same label
+// will always be given the same color so it stays stable across builds.
+func labelColor(label string) func(a ...interface{}) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(label))
+	attr := labelColors[h.Sum32()%uint32(len(labelColors))]
+	return color.New(attr).SprintFunc()
+}
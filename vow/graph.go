@@ -0,0 +1,154 @@
+package vow
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+var errGraphFailed = errors.New("at least one vow in the graph failed or was skipped")
+
+// Graph is a set of named Vows that depend on each other. Each Vow is
+// executed as soon as every Vow it depends on has passed, so independent
+// Vows are executed at the same time. A Vow that depends on one that
+// failed is skipped.
+type Graph struct {
+	nodes []*node
+}
+
+type node struct {
+	name string
+	deps []string
+	vow  *Vow
+}
+
+// Add adds v to the Graph under name. It is executed once the
+// Vows added under each of the names in deps have passed.
+func (g *Graph) Add(name string, v *Vow, deps ...string) *Graph {
+	g.nodes = append(g.nodes, &node{name: name, deps: deps, vow: v})
+	return g
+}
+
+// ThenGraph adds the Vows of g to the list of commands the Vow will execute.
+// The Vow moves on once every Vow in g has either been executed or skipped and
+// fails if any of them failed or was skipped.
+func (vow *Vow) ThenGraph(g *Graph) *Vow {
+	vow.tasks = append(vow.tasks, newGraph(g))
+	return vow
+}
+
+// graph executes the nodes of a Graph in the order of their dependencies
+type graph struct {
+	nodes      []*node
+	terminated int32
+}
+
+func newGraph(g *Graph) *graph {
+	nodes := make([]*node, len(g.nodes))
+	copy(nodes, g.nodes)
+	return &graph{nodes: nodes}
+}
+
+func (g *graph) Run(r Reporter, opts options) error {
+	opts.parallel = true
+
+	type result struct {
+		node *node
+		err  error
+	}
+	var (
+		done    = make(chan result)
+		started = make(map[*node]bool)
+		passed  = make(map[string]bool)
+		running int
+		failed  bool
+	)
+	for {
+		// start every node that has nothing left to wait on,
+		// nodes that depend on a failed one are never started
+		for _, n := range g.nodes {
+			if started[n] || g.isTerminated() || !n.ready(passed) {
+				continue
+			}
+
+			started[n] = true
+			running++
+			go func(n *node) {
+				done <- result{node: n, err: n.vow.run(r, opts)}
+			}(n)
+		}
+
+		if running == 0 {
+			break
+		}
+
+		res := <-done
+		running--
+		if res.err != nil {
+			failed = true
+		} else if res.node.name != "" {
+			passed[res.node.name] = true
+		}
+	}
+
+	if failed || len(started) < len(g.nodes) {
+		return errGraphFailed
+	}
+	return nil
+}
+
+// ready reports whether every dependency of the node has passed
+func (n *node) ready(passed map[string]bool) bool {
+	for _, d := range n.deps {
+		if !passed[d] {
+			return false
+		}
+	}
+	return true
+}
+
+func (g *graph) isTerminated() bool {
+	return atomic.LoadInt32(&g.terminated) == 1
+}
+
+func (g *graph) kill() {
+	atomic.StoreInt32(&g.terminated, 1)
+	for _, n := range g.nodes {
+		n.vow.kill()
+	}
+}
+
+func (g *graph) terminate() {
+	atomic.StoreInt32(&g.terminated, 1)
+	for _, n := range g.nodes {
+		n.vow.terminate()
+	}
+}
+
+func (g *graph) wait() {
+	for _, n := range g.nodes {
+		n.vow.wait()
+	}
+}
+
+func (g *graph) runs(failed bool) bool {
+	return !failed
+}
+
+func (g *graph) registers() []string {
+	var names []string
+	for _, n := range g.nodes {
+		names = append(names, n.vow.registers()...)
+	}
+	return names
+}
+
+func (g *graph) records() []*Step {
+	var steps []*Step
+	for _, n := range g.nodes {
+		for _, s := range n.vow.records() {
+			s.Parallel = true
+			steps = append(steps, s)
+		}
+	}
+	return steps
+}
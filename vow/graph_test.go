@@ -0,0 +1,100 @@
+package vow
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestThenGraph(t *testing.T) {
+	g := &Graph{}
+	g.Add("build", To(echoScript)).Add("test", To(echoScript), "build")
+
+	vow := To(echoScript).ThenGraph(g)
+	require.Len(t, vow.tasks, 2)
+	gr, ok := vow.tasks[1].(*graph)
+	require.True(t, ok)
+	require.Len(t, gr.nodes, 2)
+	assert.Equal(t, "test", gr.nodes[1].name)
+	assert.Equal(t, []string{"build"}, gr.nodes[1].deps)
+}
+
+func TestExecGraph(t *testing.T) {
+	g := &Graph{}
+	g.Add("build", To("sleep", "0.1"))
+	g.Add("vet", To("sleep", "0.2"), "build")
+	g.Add("test", To("sleep", "0.2"), "build")
+	g.Add("deploy", To(echoScript), "vet", "test")
+
+	start := time.Now()
+	res := (&Vow{}).ThenGraph(g).Exec(NewReporter(ioutil.Discard))
+	assert.True(t, time.Since(start) < 500*time.Millisecond, "independent steps did not run in parallel")
+
+	assert.True(t, res.Passed())
+	require.Len(t, res.Steps, 4)
+	build, vet, test, deploy := res.Steps[0], res.Steps[1], res.Steps[2], res.Steps[3]
+	assert.True(t, build.Parallel)
+	assert.False(t, vet.Start.Before(build.End))
+	assert.False(t, test.Start.Before(build.End))
+	assert.False(t, deploy.Start.Before(vet.End))
+	assert.False(t, deploy.Start.Before(test.End))
+}
+
+func TestExecGraphFailed(t *testing.T) {
+	g := &Graph{}
+	g.Add("build", To(echoScript))
+	g.Add("vet", To(failScript), "build")
+	g.Add("test", To(echoScript), "build")
+	g.Add("deploy", To(echoScript), "vet", "test")
+
+	vow := (&Vow{}).ThenGraph(g).Then(echoScript)
+	res := vow.Exec(NewReporter(ioutil.Discard))
+	assert.False(t, res.Passed())
+	require.Len(t, res.Steps, 5)
+
+	// independent branches still finish
+	assert.True(t, res.Steps[0].Passed())
+	assert.False(t, res.Steps[1].Passed())
+	assert.True(t, res.Steps[2].Passed())
+
+	// but anything downstream of the failure is skipped
+	assert.True(t, res.Steps[3].Skipped)
+	assert.True(t, res.Steps[4].Skipped)
+}
+
+func TestExecGraphUnknownDependency(t *testing.T) {
+	g := &Graph{}
+	g.Add("build", To(echoScript))
+	g.Add("test", To(echoScript), "missing")
+
+	res := (&Vow{}).ThenGraph(g).Exec(NewReporter(ioutil.Discard))
+	assert.False(t, res.Passed())
+	require.Len(t, res.Steps, 2)
+	assert.True(t, res.Steps[0].Passed())
+	assert.True(t, res.Steps[1].Skipped)
+}
+
+func TestStopGraph(t *testing.T) {
+	g := &Graph{}
+	g.Add("a", To("sleep", "5"))
+	g.Add("b", To("sleep", "5"))
+	g.Add("c", To(echoScript), "a", "b")
+	vow := (&Vow{}).ThenGraph(g)
+
+	done := make(chan *Result)
+	go func() { done <- vow.Exec(NewReporter(ioutil.Discard)) }()
+	<-time.After(100 * time.Millisecond)
+
+	start := time.Now()
+	vow.Stop()
+	assert.True(t, time.Since(start) < 5*time.Second, "graph was not stopped")
+
+	res := <-done
+	assert.True(t, res.Canceled)
+	assert.True(t, res.Steps[0].Canceled)
+	assert.True(t, res.Steps[1].Canceled)
+	assert.False(t, res.Steps[2].Passed())
+}
@@ -0,0 +1,96 @@
+package vow
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+var errGroupFailed = errors.New("at least one vow in the group failed")
+
+// group executes several Vows at the same time
+type group struct {
+	vows []*Vow
+
+	// limit is the maximum amount of vows executed at
+	// the same time, there is no limit when it is zero
+	limit int
+}
+
+func newGroup(vows []*Vow) *group {
+	return &group{vows: vows}
+}
+
+func (g *group) Run(r Reporter, opts options) error {
+	limit := g.limit
+	if limit <= 0 || limit > len(g.vows) {
+		limit = len(g.vows)
+	}
+	sem := make(chan struct{}, limit)
+	opts.parallel = true
+
+	// every vow gets to finish even if one of them fails
+	var (
+		wg     sync.WaitGroup
+		failed int32
+	)
+	for _, v := range g.vows {
+		wg.Add(1)
+		go func(v *Vow) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			if err := v.run(r, opts); err != nil {
+				atomic.StoreInt32(&failed, 1)
+			}
+		}(v)
+	}
+	wg.Wait()
+
+	if failed == 1 {
+		return errGroupFailed
+	}
+	return nil
+}
+
+func (g *group) kill() {
+	for _, v := range g.vows {
+		v.kill()
+	}
+}
+
+func (g *group) terminate() {
+	for _, v := range g.vows {
+		v.terminate()
+	}
+}
+
+func (g *group) wait() {
+	for _, v := range g.vows {
+		v.wait()
+	}
+}
+
+func (g *group) runs(failed bool) bool {
+	return !failed
+}
+
+func (g *group) registers() []string {
+	var names []string
+	for _, v := range g.vows {
+		names = append(names, v.registers()...)
+	}
+	return names
+}
+
+func (g *group) records() []*Step {
+	var steps []*Step
+	for _, v := range g.vows {
+		for _, s := range v.records() {
+			s.Parallel = true
+			steps = append(steps, s)
+		}
+	}
+	return steps
+}
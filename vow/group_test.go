@@ -0,0 +1,101 @@
+package vow
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestThenAll(t *testing.T) {
+	vow := To(echoScript)
+	vow.ThenAll(To(echoScript), To(failScript)).Limit(1)
+
+	require.Len(t, vow.tasks, 2)
+	g, ok := vow.tasks[1].(*group)
+	require.True(t, ok)
+	assert.Len(t, g.vows, 2)
+	assert.Equal(t, 1, g.limit)
+}
+
+func TestLimitWithoutGroup(t *testing.T) {
+	vow := To(echoScript).Limit(2)
+	assert.Len(t, vow.tasks, 1)
+}
+
+func TestExecGroup(t *testing.T) {
+	vow := To(echoScript)
+	vow.ThenAll(
+		To("sleep", "0.2"),
+		To("sleep", "0.2"),
+		To("sleep", "0.2"),
+	)
+	vow.Then(echoScript)
+
+	start := time.Now()
+	res := vow.Exec(NewReporter(ioutil.Discard))
+	assert.True(t, time.Since(start) < 500*time.Millisecond, "group did not run in parallel")
+
+	assert.True(t, res.Passed())
+	require.Len(t, res.Steps, 5)
+	assert.False(t, res.Steps[0].Parallel)
+	for _, s := range res.Steps[1:4] {
+		assert.True(t, s.Parallel)
+	}
+	assert.False(t, res.Steps[4].Parallel)
+}
+
+func TestExecGroupLimit(t *testing.T) {
+	vow := To(echoScript)
+	vow.ThenAll(
+		To("sleep", "0.1"),
+		To("sleep", "0.1"),
+		To("sleep", "0.1"),
+	).Limit(1)
+
+	start := time.Now()
+	res := vow.Exec(NewReporter(ioutil.Discard))
+	assert.True(t, time.Since(start) >= 300*time.Millisecond, "group did not respect its limit")
+	assert.True(t, res.Passed())
+}
+
+func TestExecGroupFailed(t *testing.T) {
+	vow := To(echoScript)
+	vow.ThenAll(
+		To(failScript),
+		To("sleep", "0.1").Then(echoScript),
+	)
+	vow.Then(echoScript)
+
+	res := vow.Exec(NewReporter(ioutil.Discard))
+	assert.False(t, res.Passed())
+	require.Len(t, res.Steps, 5)
+
+	// every vow in the group finishes
+	assert.False(t, res.Steps[1].Passed())
+	assert.True(t, res.Steps[2].Passed())
+	assert.True(t, res.Steps[3].Passed())
+
+	// but the vow does not go any further
+	assert.True(t, res.Steps[4].Skipped)
+}
+
+func TestStopGroup(t *testing.T) {
+	vow := To(echoScript)
+	vow.ThenAll(To("sleep", "5"), To("sleep", "5"))
+
+	done := make(chan *Result)
+	go func() { done <- vow.Exec(NewReporter(ioutil.Discard)) }()
+	<-time.After(100 * time.Millisecond)
+
+	start := time.Now()
+	vow.Stop()
+	assert.True(t, time.Since(start) < 5*time.Second, "group was not stopped")
+
+	res := <-done
+	assert.True(t, res.Canceled)
+	assert.True(t, res.Steps[1].Canceled)
+	assert.True(t, res.Steps[2].Canceled)
+}
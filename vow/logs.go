@@ -0,0 +1,49 @@
+package vow
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// unsafeChars matches what is replaced in the names of log files
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// logDir hands out a log file for each command of a Vow that
+// is executing. A nil logDir does not keep any logs.
+type logDir struct {
+	dir string
+
+	mtx  sync.Mutex
+	used map[string]int
+}
+
+func newLogDir(dir string) *logDir {
+	if dir == "" {
+		return nil
+	}
+	return &logDir{dir: dir, used: make(map[string]int)}
+}
+
+// create creates a new log file named after the label of a command
+func (l *logDir) create(label string) (*os.File, error) {
+	if l == nil {
+		return nil, nil
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if err := os.MkdirAll(l.dir, 0755); err != nil {
+		return nil, err
+	}
+
+	name := unsafeChars.ReplaceAllString(label, "_")
+	l.used[name]++
+	if n := l.used[name]; n > 1 {
+		name = fmt.Sprintf("%s-%d", name, n)
+	}
+	return os.Create(filepath.Join(l.dir, name+".log"))
+}
@@ -0,0 +1,18 @@
+package vow
+
+import (
+	"os"
+	"os/exec"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// terminateProcess kills the process since
+// windows has no way of asking it to exit
+func terminateProcess(p *os.Process) error {
+	return p.Kill()
+}
+
+func killProcess(p *os.Process) error {
+	return p.Kill()
+}
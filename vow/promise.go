@@ -2,10 +2,13 @@ package vow
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
+	"runtime/debug"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -15,41 +18,50 @@ import (
 
 var errKilled = errors.New("promise has already been killed")
 
-var (
-	statusFailed     = "\r|" + red("Failed") + "     |\n"
-	statusPassed     = "\r|" + green("Passed") + "     |\n"
-	statusInProgress = "|" + yellow("In Progress") + "|"
-)
+// killTimeout is how long a terminated command has to exit
+// before it is killed
+var killTimeout = 5 * time.Second
 
 type syncBuffer struct {
 	sync.RWMutex
 
 	buf *bytes.Buffer
+
+	// limit is the most the buffer holds, anything written
+	// past it is dropped and overflowed is set
+	limit      int
+	overflowed bool
 }
 
-func newSyncBuffer() *syncBuffer {
-	return &syncBuffer{buf: bytes.NewBuffer([]byte{})}
+func newSyncBuffer(limit int) *syncBuffer {
+	return &syncBuffer{buf: bytes.NewBuffer([]byte{}), limit: limit}
 }
 
 func (sb *syncBuffer) Write(p []byte) (int, error) {
 	sb.Lock()
-	n, err := sb.buf.Write(p)
-	sb.Unlock()
-	return n, err
+	defer sb.Unlock()
+
+	// the write still succeeds so the writers
+	// the buffer is written to along with go on
+	if sb.buf.Len()+len(p) > sb.limit {
+		sb.overflowed = true
+		return len(p), nil
+	}
+	return sb.buf.Write(p)
 }
 
-func (sb *syncBuffer) Read(p []byte) (int, error) {
+// full reports whether anything was dropped
+func (sb *syncBuffer) full() bool {
 	sb.RLock()
-	n, err := sb.buf.Read(p)
-	sb.RUnlock()
-	return n, err
+	defer sb.RUnlock()
+	return sb.overflowed
 }
 
-func (sb *syncBuffer) Next(n int) []byte {
+func (sb *syncBuffer) Read(p []byte) (int, error) {
 	sb.RLock()
-	b := sb.buf.Next(n)
+	n, err := sb.buf.Read(p)
 	sb.RUnlock()
-	return b
+	return n, err
 }
 
 func (sb *syncBuffer) Bytes() []byte {
@@ -59,17 +71,85 @@ func (sb *syncBuffer) Bytes() []byte {
 	return b
 }
 
+// options are the settings of a Vow that affect
+// how each of its promises is run
+type options struct {
+	// ctx is the context the Vow is executed with,
+	// functions are given a context derived from it
+	ctx context.Context
+
+	stream bool
+
+	// parallel is set for promises that are run
+	// at the same time as others
+	parallel bool
+
+	// vars holds the output registered by
+	// the promises that have already run
+	vars *vars
+
+	// logs is where the full output of each promise is written
+	logs *logDir
+
+	// tty runs every command under a pseudo-terminal
+	tty bool
+}
+
+// when a promise runs depending on the
+// promises that were run before it
+type when int
+
+const (
+	whenPassed when = iota
+	whenFailed
+	whenAlways
+)
+
 type promise struct {
-	cmdMtx sync.Mutex
-	cmd    *exec.Cmd
-	async  bool
-	killed *int32
+	cmdMtx     sync.Mutex
+	cmd        *exec.Cmd
+	started    bool
+	async      bool
+	killed     int32
+	terminated int32
+	stop       chan struct{}
+	name       string
+	cache      Cache
+	done       chan struct{}
+
+	when         when
+	allowFailure bool
+
+	// fn is called instead of running a command
+	// for promises added with ThenFunc
+	fn     func(ctx context.Context, out io.Writer) error
+	cancel context.CancelFunc
+
+	// log gets everything the command writes
+	log *os.File
+
+	// register is the name the command's stdout
+	// is saved under once it passes
+	register string
+
+	// tty runs the command under a pseudo-terminal
+	tty bool
+
+	// retries is how many more times the command
+	// is run when it fails, waiting retryDelay first
+	retries    int
+	retryDelay time.Duration
+
+	// step is the record of the promise once it has run
+	stepMtx sync.Mutex
+	step    *Step
 }
 
 func newPromise(name string, args ...string) *promise {
 	return &promise{
-		cmd:    exec.Command(name, args...),
-		killed: new(int32),
+		cmd:  newCmd(name, args...),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
 	}
 }
 
@@ -79,96 +159,516 @@ func newAsyncPromise(name string, args ...string) *promise {
 	return p
 }
 
-func (p *promise) Run(w io.Writer, verbose bool) (err error) {
-	if p.isKilled() {
+func newFuncPromise(name string, fn func(ctx context.Context, out io.Writer) error) *promise {
+	return &promise{
+		name: name,
+		fn:   fn,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+func newCmd(name string, args ...string) *exec.Cmd {
+	cmd := exec.Command(name, args...)
+	setProcessGroup(cmd)
+	return cmd
+}
+
+// label returns the name used to identify the promise's output
+func (p *promise) label() string {
+	if p.name != "" {
+		return p.name
+	}
+	return strings.Join(p.args(), " ")
+}
+
+// args returns the command the promise runs, a
+// function is identified by its name instead
+func (p *promise) args() []string {
+	if p.fn != nil {
+		return []string{p.name}
+	}
+	return p.cmd.Args
+}
+
+func (p *promise) Run(r Reporter, opts options) (err error) {
+	if p.isTerminated() {
 		return errKilled
 	}
 
-	buf := newSyncBuffer()
-	p.cmd.Stdout = buf
-	p.cmd.Stderr = buf
+	step := p.newStep()
+	step.Streamed = p.async || opts.stream
+	step.Parallel = opts.parallel
+	p.stepMtx.Lock()
+	p.step = step
+	p.stepMtx.Unlock()
 
-	fmt.Fprintf(
-		w,
-		"%s %s",
-		statusInProgress,
-		strings.Join(p.cmd.Args, " "),
+	// there is nothing to do if the work of the
+	// command has been done before
+	if !p.async && p.cache != nil && p.cache.Valid() {
+		p.stepMtx.Lock()
+		step.Cached = true
+		step.Start = time.Now()
+		p.stepMtx.Unlock()
+
+		p.report(func() { r.OnStart(step) })
+		p.finish(r, step, nil, nil)
+		return nil
+	}
+
+	// logs are kept on a best effort basis
+	if f, err := opts.logs.create(p.label()); err == nil && f != nil {
+		p.log = f
+		p.stepMtx.Lock()
+		step.LogFile = f.Name()
+		p.stepMtx.Unlock()
+	}
+
+	var out []byte
+	for {
+		var stdout *syncBuffer
+		if p.register != "" {
+			stdout = newSyncBuffer(maxRegister)
+		}
+
+		var wait func() ([]byte, error)
+		wait, err = p.attempt(r, step, opts, stdout)
+		switch {
+		case err == errKilled && step.Attempt > 1:
+			// stopped right before running again so
+			// the last failure is what the step ended with
+			err = step.Err
+		case err == errKilled:
+			p.closeLog()
+			return err
+		case err != nil:
+			out = []byte(err.Error() + "\n")
+		case p.async:
+			// if the process is async we don't need to wait for it
+			go func() {
+				out, err := wait()
+				p.finish(r, step, err, out)
+			}()
+			return nil
+		default:
+			out, err = wait()
+		}
+
+		if err == nil && stdout != nil && stdout.full() {
+			err = fmt.Errorf("the output is too large to register as %s, it can be at most %d bytes", p.register, maxRegister)
+			out = append(out, err.Error()+"\n"...)
+		}
+		if err == nil && p.cache != nil {
+			p.cache.Save()
+		}
+		if err == nil && stdout != nil {
+			opts.vars.set(p.register, strings.TrimSpace(string(stdout.Bytes())))
+		}
+		if err == nil || !p.retry(r, step, err, out) {
+			p.finish(r, step, err, out)
+			if p.allowFailure && !p.isTerminated() {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// attempt starts the promise's command, with the variables registered so
+// far filled in, and returns a function that waits for it to exit. What
+// the command writes to stdout is also written to stdout if it is set.
+func (p *promise) attempt(r Reporter, step *Step, opts options, stdout *syncBuffer) (func() ([]byte, error), error) {
+	// async output is reported line by line as it comes in
+	// while everything else is held on to until the command exits
+	// unless it is being streamed
+	var (
+		buf *capBuffer
+		lw  *lineWriter
+		out io.Writer
 	)
+	if step.Streamed {
+		lw = newLineWriter(func(line []byte) {
+			p.report(func() { r.OnOutput(step, line) })
+		})
+	}
 
-	p.cmdMtx.Lock()
-	if err := p.cmd.Start(); err != nil {
-		p.cmdMtx.Unlock()
-		p.writeIfAlive(w, []byte(statusFailed))
-		p.writeIfAlive(w, []byte(err.Error()+"\n"))
-		return err
+	switch {
+	case p.async:
+		// async commands don't hold on to their output
+		// since they could be running for a very long time
+		out = lw
+	case opts.stream:
+		buf = newCapBuffer(step.LogFile)
+		out = io.MultiWriter(buf, lw)
+	default:
+		buf = newCapBuffer(step.LogFile)
+		out = buf
+	}
+	if p.log != nil {
+		out = io.MultiWriter(out, p.log)
 	}
-	p.cmdMtx.Unlock()
 
-	// if the process is async we don't need to do anything else
-	if p.async {
-		fmt.Println(" -- process id: ", p.cmd.Process.Pid)
-		go p.fowardOutput(p.cmd.Process.Pid, w, buf)
-		go p.wait(w, verbose, buf)
-		return nil
+	var wait func() error
+	var err error
+	if p.fn != nil {
+		wait, err = p.startFunc(step, opts, out, stdout)
+	} else {
+		wait, err = p.startCmd(step, opts, out, stdout)
+	}
+	if err == errKilled {
+		return nil, err
 	}
 
-	return p.wait(w, verbose, buf)
+	p.report(func() { r.OnStart(step) })
+	if err != nil {
+		return nil, err
+	}
+
+	return func() ([]byte, error) {
+		err := wait()
+		if lw != nil {
+			lw.Flush()
+		}
+		if buf == nil {
+			return nil, err
+		}
+
+		out := buf.Bytes()
+		p.stepMtx.Lock()
+		step.Truncated = buf.Truncated()
+		p.stepMtx.Unlock()
+		return out, err
+	}, nil
 }
 
-func (p *promise) wait(w io.Writer, verbose bool, buf *syncBuffer) error {
+// startCmd starts a new process for the promise's command and
+// returns a function that waits for it to exit
+func (p *promise) startCmd(step *Step, opts options, out io.Writer, stdout *syncBuffer) (func() error, error) {
 	p.cmdMtx.Lock()
-	err := p.cmd.Wait()
-	p.cmdMtx.Unlock()
+	defer p.cmdMtx.Unlock()
+
+	// the promise could have been stopped while getting ready
+	if p.isTerminated() {
+		return nil, errKilled
+	}
 
-	status := statusPassed
+	// a variable without a value fails the
+	// attempt like a command that can't start
+	args, err := opts.vars.expand(step.Args)
 	if err != nil {
-		status = statusFailed
+		p.stepMtx.Lock()
+		step.Attempt++
+		if step.Start.IsZero() {
+			step.Start = time.Now()
+		}
+		p.stepMtx.Unlock()
+		return nil, err
+	}
+
+	// a command can only be started once
+	if p.started || !equal(args, p.cmd.Args) {
+		p.cmd = newCmd(args[0], args[1:]...)
+	}
+	cmd := p.cmd
+	cmd.Stderr = out
+	cmd.Stdout = out
+	if stdout != nil {
+		cmd.Stdout = io.MultiWriter(out, stdout)
+	}
+	if env := opts.vars.environ(); env != nil {
+		cmd.Env = append(os.Environ(), env...)
 	}
 
-	if p.async {
-		status = status[1 : len(status)-1]
-		status = fmt.Sprintf("%s %s\n", status, strings.Join(p.cmd.Args, " "))
+	// a terminal only has the one output, so it is
+	// copied to wherever stdout would have gone
+	var tty *terminal
+	if p.tty || opts.tty {
+		w := cmd.Stdout
+		var err error
+		if tty, err = openTerminal(cmd); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if cmd.Process != nil {
+				tty.start(w)
+			} else {
+				tty.close()
+			}
+		}()
 	}
+	p.started = true
 
-	p.writeIfAlive(w, []byte(status))
+	p.stepMtx.Lock()
+	defer p.stepMtx.Unlock()
+	step.Args = args
+	step.Attempt++
+	if step.Start.IsZero() {
+		step.Start = time.Now()
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	step.Pid = cmd.Process.Pid
+
+	// the lock is not held while waiting so that
+	// the command can be killed while it runs
+	if tty != nil {
+		return func() error {
+			err := cmd.Wait()
+			tty.wait()
+			return err
+		}, nil
+	}
+	return cmd.Wait, nil
+}
+
+// startFunc calls the promise's function in the background and returns
+// a function that waits for it to return. Its context is derived from
+// the one the Vow is executed with and is canceled when the promise is
+// terminated. A panic in the function fails the step.
+func (p *promise) startFunc(step *Step, opts options, out io.Writer, stdout *syncBuffer) (func() error, error) {
+	p.cmdMtx.Lock()
+	defer p.cmdMtx.Unlock()
+
+	if p.isTerminated() {
+		return nil, errKilled
+	}
+
+	parent := opts.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithCancel(parent)
+	p.cancel = cancel
+	p.started = true
+
+	p.stepMtx.Lock()
+	step.Attempt++
+	if step.Start.IsZero() {
+		step.Start = time.Now()
+	}
+	p.stepMtx.Unlock()
 
-	if verbose || err != nil {
-		p.writeIfAlive(w, buf.Bytes())
+	if stdout != nil {
+		out = io.MultiWriter(out, stdout)
 	}
+	// the function could write from several goroutines
+	out = newSyncWriter(out)
 
-	return err
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				fmt.Fprintf(out, "panic: %v\n\n%s", r, debug.Stack())
+				done <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		done <- p.fn(ctx, out)
+	}()
+	return func() error {
+		defer cancel()
+		return <-done
+	}, nil
 }
 
-func (p *promise) fowardOutput(pid int, w io.Writer, buf *syncBuffer) {
-	prefix := []byte(yellow(fmt.Sprintf("pid %d : ", pid)))
-	for t := time.Tick(time.Second); !p.isKilled(); <-t {
-		b := buf.Next(1024)
-		if len(b) == 0 {
-			continue
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
 		}
+	}
+	return true
+}
+
+// retry reports the failed attempt of the step and waits to run it again.
+// It returns false when the step has no attempts left or was stopped.
+func (p *promise) retry(r Reporter, step *Step, err error, out []byte) bool {
+	if step.Attempt > p.retries || p.isTerminated() {
+		return false
+	}
+
+	p.stepMtx.Lock()
+	step.End = time.Now()
+	step.Err = err
+	step.ExitCode, step.Signal = exitStatus(err)
+	step.Output = out
+	step.Retrying = true
+	p.stepMtx.Unlock()
+
+	p.report(func() { r.OnFinish(step) })
+
+	select {
+	case <-time.After(p.retryDelay):
+	case <-p.stop:
+		p.stepMtx.Lock()
+		step.Retrying = false
+		p.stepMtx.Unlock()
+		return false
+	}
+
+	p.stepMtx.Lock()
+	step.End = time.Time{}
+	step.Retrying = false
+	p.stepMtx.Unlock()
+	return true
+}
+
+// finish records how the promise's command ended and reports it
+func (p *promise) finish(r Reporter, step *Step, err error, out []byte) {
+	defer close(p.done)
+	p.closeLog()
+
+	p.stepMtx.Lock()
+	step.End = time.Now()
+	step.Err = err
+	step.ExitCode, step.Signal = exitStatus(err)
+	step.Output = out
+	step.Canceled = p.isTerminated()
+	p.stepMtx.Unlock()
+
+	p.report(func() { r.OnFinish(step) })
+}
+
+func (p *promise) closeLog() {
+	if p.log != nil {
+		_ = p.log.Close()
+	}
+}
 
-		p.writeIfAlive(w, append(prefix, b...))
+func (p *promise) newStep() *Step {
+	return &Step{
+		Name:         p.name,
+		Args:         p.args(),
+		Async:        p.async,
+		Retries:      p.retries,
+		AllowFailure: p.allowFailure,
+		OnFailure:    p.when == whenFailed,
 	}
 }
 
-func (p *promise) writeIfAlive(w io.Writer, b []byte) {
+func (p *promise) runs(failed bool) bool {
+	switch p.when {
+	case whenFailed:
+		return failed
+	case whenAlways:
+		return true
+	}
+	return !failed
+}
+
+func (p *promise) registers() []string {
+	if p.register == "" {
+		return nil
+	}
+	return []string{p.register}
+}
+
+// records returns a copy of the promise's step as it is right
+// now. Promises that never ran are marked as skipped, or as
+// canceled if they were stopped before they got the chance.
+func (p *promise) records() []*Step {
+	p.stepMtx.Lock()
+	defer p.stepMtx.Unlock()
+
+	if p.step == nil {
+		s := p.newStep()
+		s.Canceled = p.isTerminated()
+		s.Skipped = !s.Canceled
+		return []*Step{s}
+	}
+
+	s := *p.step
+	return []*Step{&s}
+}
+
+// report calls fn unless the promise has been killed, in
+// which case nobody is interested in its progress anymore
+func (p *promise) report(fn func()) {
 	if p.isKilled() {
 		return
 	}
-	// ignoring error since there is not much we can do
-	_, _ = w.Write(b)
+	fn()
 }
 
 func (p *promise) isKilled() bool {
-	return atomic.LoadInt32(p.killed) == 1
+	return atomic.LoadInt32(&p.killed) == 1
 }
 
+func (p *promise) isTerminated() bool {
+	return atomic.LoadInt32(&p.terminated) == 1
+}
+
+// kill terminates the promise and silences
+// anything it would report from now on
 func (p *promise) kill() {
-	atomic.StoreInt32(p.killed, 1)
+	atomic.StoreInt32(&p.killed, 1)
+	p.terminate()
+}
+
+// terminate asks the promise's command to exit, if it is running,
+// and kills it if it has not done so after killTimeout. A promise
+// that has not run yet never will.
+func (p *promise) terminate() {
+	if !atomic.CompareAndSwapInt32(&p.terminated, 0, 1) {
+		return
+	}
+	close(p.stop)
+
 	p.cmdMtx.Lock()
-	if p.cmd.Process != nil {
-		// if we can't signal the process assume it has died
-		_ = p.cmd.Process.Signal(syscall.SIGTERM)
+	defer p.cmdMtx.Unlock()
+	if p.cancel != nil {
+		p.cancel()
 	}
+	if p.cmd == nil || p.cmd.Process == nil {
+		return
+	}
+
+	// if we can't signal the process assume it has died
+	_ = terminateProcess(p.cmd.Process)
+	go func(proc *os.Process, timeout time.Duration) {
+		select {
+		case <-p.done:
+		case <-time.After(timeout):
+			_ = killProcess(proc)
+		}
+	}(p.cmd.Process, killTimeout)
+}
+
+// wait blocks until the promise's command has
+// exited if it was ever started
+func (p *promise) wait() {
+	p.cmdMtx.Lock()
+	started := p.started
 	p.cmdMtx.Unlock()
+
+	if started {
+		<-p.done
+	}
+}
+
+// exitStatus returns the exit code of the process that returned err,
+// which is -1 if the process did not exit normally, along with the
+// signal that terminated it, if any
+func exitStatus(err error) (int, string) {
+	if err == nil {
+		return 0, ""
+	}
+
+	ee, ok := err.(*exec.ExitError)
+	if !ok {
+		return -1, ""
+	}
+
+	ws, ok := ee.Sys().(syscall.WaitStatus)
+	if !ok {
+		return -1, ""
+	}
+
+	if ws.Signaled() {
+		return -1, ws.Signal().String()
+	}
+	return ws.ExitStatus(), ""
 }
@@ -0,0 +1,361 @@
+package vow
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Step is the record of a single command run by a Vow
+type Step struct {
+	// Name is the name given to the command with As, if any
+	Name string
+	Args []string
+
+	// Async is set for commands added with ThenAsync
+	Async bool
+	Pid   int
+
+	// Streamed is set when the command's output is
+	// reported as it is written
+	Streamed bool
+
+	// Parallel is set for commands added with ThenAll
+	// that ran at the same time as others
+	Parallel bool
+
+	Start time.Time
+	End   time.Time
+
+	// Attempt is the number of times the command has been run,
+	// it goes up to one more than Retries for a failing command
+	Attempt int
+	Retries int
+
+	// Retrying is set when a failed attempt of the
+	// command is reported and it is going to run again
+	Retrying bool
+
+	// ExitCode is -1 if the command did not exit on its own,
+	// in which case Signal may hold what terminated it
+	ExitCode int
+	Signal   string
+	Err      error
+
+	// Skipped is set when the command never ran because a
+	// command before it failed
+	Skipped bool
+
+	// AllowFailure is set when the command failing
+	// does not fail the Vow
+	AllowFailure bool
+
+	// OnFailure is set when the command only runs
+	// after a command before it failed
+	OnFailure bool
+
+	// Cached is set when the command did not need to
+	// run because its Cache was still valid
+	Cached bool
+
+	// Canceled is set when the Vow was stopped before
+	// or while the command was running
+	Canceled bool
+
+	// Output holds what the command wrote once it has finished.
+	// Only the first and last lines are held on to, Truncated is
+	// the amount of lines in between that were left out.
+	Output    []byte
+	Truncated int
+
+	// LogFile is the file with everything the command
+	// wrote when the Vow keeps logs
+	LogFile string
+}
+
+// Label returns the name of the step or its command if it has none
+func (s *Step) Label() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return s.Command()
+}
+
+// Command returns the command the step runs
+func (s *Step) Command() string {
+	return strings.Join(s.Args, " ")
+}
+
+// Warned reports whether the command failed but was allowed to
+func (s *Step) Warned() bool {
+	return s.AllowFailure && s.Err != nil && !s.Skipped && !s.Canceled
+}
+
+// Retried reports whether the command had to run more than once
+func (s *Step) Retried() bool {
+	return s.Attempt > 1
+}
+
+// Duration returns how long the command ran for
+func (s *Step) Duration() time.Duration {
+	switch {
+	case s.Start.IsZero():
+		return 0
+	case s.End.IsZero():
+		return time.Since(s.Start)
+	}
+	return s.End.Sub(s.Start)
+}
+
+// Running reports whether the command had started
+// but not yet exited when the step was recorded
+func (s *Step) Running() bool {
+	return !s.Start.IsZero() && s.End.IsZero()
+}
+
+// Passed reports whether the command ran and was successful
+func (s *Step) Passed() bool {
+	return s.Err == nil && !s.Skipped && !s.Canceled
+}
+
+// Cache decides whether a command needs to run again
+type Cache interface {
+	// Valid reports whether the work done by the
+	// last passing run of the command is still good
+	Valid() bool
+
+	// Save is called every time the command passes
+	Save()
+}
+
+// Reporter is notified of the progress of a Vow while it executes.
+// Output from async commands is reported as it is written so its
+// methods may be called concurrently.
+type Reporter interface {
+	// OnStart is called when a command is started
+	OnStart(s *Step)
+
+	// OnOutput is called with each line of output of a command
+	// whose output is not held on to until it finishes
+	OnOutput(s *Step, line []byte)
+
+	// OnFinish is called once a command has exited
+	OnFinish(s *Step)
+
+	// OnCancel is called when a Vow is stopped while executing
+	OnCancel()
+}
+
+// statuses are the lines a TextReporter writes for the status of a step
+type statuses struct {
+	failed     string
+	passed     string
+	cached     string
+	retrying   string
+	warning    string
+	inProgress string
+}
+
+// coloredStatuses returns the statuses in color, the colors are left
+// out if they have been turned off with color.NoColor
+func coloredStatuses() statuses {
+	return statuses{
+		failed:     "\r|" + red("Failed") + "     |\n",
+		passed:     "\r|" + green("Passed") + "     |\n",
+		cached:     "\r|" + green("Cached") + "     |\n",
+		retrying:   "\r|" + yellow("Retrying") + "   |\n",
+		warning:    "\r|" + yellow("Warning") + "    |\n",
+		inProgress: "|" + yellow("In Progress") + "|",
+	}
+}
+
+var plainStatuses = statuses{
+	failed:     "\r|Failed     |\n",
+	passed:     "\r|Passed     |\n",
+	cached:     "\r|Cached     |\n",
+	retrying:   "\r|Retrying   |\n",
+	warning:    "\r|Warning    |\n",
+	inProgress: "|In Progress|",
+}
+
+// TextReporter writes the progress of a Vow as
+// a status line for each of its commands
+type TextReporter struct {
+	// Verbose writes the output of commands that passed
+	// as well as the ones that failed
+	Verbose bool
+
+	// Timestamps prefixes each line of output from
+	// async commands with the time it was written
+	Timestamps bool
+
+	w     io.Writer
+	color bool
+	statuses
+
+	mtx        sync.Mutex
+	labelWidth int
+}
+
+// NewReporter returns a TextReporter that writes colored output to w
+func NewReporter(w io.Writer) *TextReporter {
+	return &TextReporter{
+		// async commands write to w concurrently
+		w:        newSyncWriter(w),
+		color:    true,
+		statuses: coloredStatuses(),
+	}
+}
+
+// NewPlainReporter returns a TextReporter that writes output
+// to w without any colors
+func NewPlainReporter(w io.Writer) *TextReporter {
+	return &TextReporter{
+		w:        newSyncWriter(w),
+		statuses: plainStatuses,
+	}
+}
+
+// SetVerbose changes whether the output of commands that passed is
+// written, it is safe to call while the reporter is in use
+func (r *TextReporter) SetVerbose(verbose bool) {
+	r.mtx.Lock()
+	r.Verbose = verbose
+	r.mtx.Unlock()
+}
+
+// Reset forgets the labels of the steps seen so far, the output of the
+// next execution is lined up with only its own labels
+func (r *TextReporter) Reset() {
+	r.mtx.Lock()
+	r.labelWidth = 0
+	r.mtx.Unlock()
+}
+
+// OnStart writes the in progress status of the step
+func (r *TextReporter) OnStart(s *Step) {
+	if labeled(s) {
+		r.mtx.Lock()
+		if l := len(s.Label()); l > r.labelWidth {
+			r.labelWidth = l
+		}
+		r.mtx.Unlock()
+	}
+
+	switch {
+	case s.Async:
+		fmt.Fprintf(r.w, "%s %s -- process id: %d\n", r.inProgress, s.Command(), s.Pid)
+	case ownLine(s):
+		// something else will be written before the step
+		// finishes so the status needs to be on its own line
+		fmt.Fprintf(r.w, "%s %s\n", r.inProgress, describe(s))
+	default:
+		fmt.Fprintf(r.w, "%s %s", r.inProgress, describe(s))
+	}
+}
+
+// OnOutput writes the line as is unless its output could be mixed
+// with another command's, in which case it is prefixed with the
+// step's label
+func (r *TextReporter) OnOutput(s *Step, line []byte) {
+	if !labeled(s) {
+		_, _ = r.w.Write(line)
+		return
+	}
+
+	r.mtx.Lock()
+	width := r.labelWidth
+	r.mtx.Unlock()
+
+	label := s.Label()
+	if pad := width - len(label); pad > 0 {
+		label += strings.Repeat(" ", pad)
+	}
+	prefix := label + " |"
+	if r.color {
+		prefix = labelColor(s.Label())(prefix)
+	}
+	prefix += " "
+	if r.Timestamps {
+		prefix = time.Now().Format("15:04:05") + " " + prefix
+	}
+
+	b := make([]byte, 0, len(prefix)+len(line))
+	b = append(b, prefix...)
+	b = append(b, line...)
+	_, _ = r.w.Write(b)
+}
+
+// OnFinish writes the final status of the step followed by its
+// output if it failed or the reporter is verbose
+func (r *TextReporter) OnFinish(s *Step) {
+	status := r.passed
+	switch {
+	case s.Retrying:
+		status = r.retrying
+	case s.Warned():
+		status = r.warning
+	case !s.Passed():
+		status = r.failed
+	case s.Cached:
+		status = r.cached
+	}
+
+	// anything that had output written after its in progress
+	// status gets a new line instead of overwriting the old one
+	if ownLine(s) {
+		status = status[1 : len(status)-1]
+		status = fmt.Sprintf("%s %s\n", status, describe(s))
+	}
+
+	r.mtx.Lock()
+	verbose := r.Verbose
+	r.mtx.Unlock()
+
+	var out []byte
+	switch {
+	case s.Async:
+		// output was written as it came in
+	case s.Streamed:
+		// the output has already been written, repeat the
+		// end of it so the reason for the failure is at hand
+		if !s.Passed() {
+			out = lastLines(s.Output, failureSummaryLines)
+		}
+	case verbose || !s.Passed():
+		out = s.Output
+	}
+
+	// written all at once so it doesn't get mixed
+	// up with output from other commands
+	b := make([]byte, 0, len(status)+len(out))
+	b = append(b, status...)
+	b = append(b, out...)
+	_, _ = r.w.Write(b)
+}
+
+// OnCancel does nothing, there is nothing left to say
+func (r *TextReporter) OnCancel() {}
+
+// describe returns the command of the step along
+// with its attempt if it has been retried
+func describe(s *Step) string {
+	if !s.Retried() {
+		return s.Command()
+	}
+	return fmt.Sprintf("%s (attempt %d of %d)", s.Command(), s.Attempt, s.Retries+1)
+}
+
+// labeled reports whether the output of the step could be
+// mixed with the output of other steps
+func labeled(s *Step) bool {
+	return s.Async || s.Parallel
+}
+
+// ownLine reports whether anything could be written
+// between the step's start and its finish
+func ownLine(s *Step) bool {
+	return s.Async || s.Streamed || s.Parallel
+}
@@ -0,0 +1,130 @@
+package vow
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlainReporter(t *testing.T) {
+	var buf bytes.Buffer
+	r := NewPlainReporter(&buf)
+
+	s := &Step{Args: []string{"go", "test"}, Output: []byte("FAIL\n")}
+	r.OnStart(s)
+	s.Err = errors.New("exit status 1")
+	r.OnFinish(s)
+
+	assert.Equal(t, "|In Progress| go test\r|Failed     |\nFAIL\n", buf.String())
+}
+
+func TestPlainReporterCached(t *testing.T) {
+	var buf bytes.Buffer
+	r := NewPlainReporter(&buf)
+
+	s := &Step{Args: []string{"protoc"}, Cached: true}
+	r.OnStart(s)
+	r.OnFinish(s)
+
+	assert.Equal(t, "|In Progress| protoc\r|Cached     |\n", buf.String())
+}
+
+func TestPlainReporterRetry(t *testing.T) {
+	var buf bytes.Buffer
+	r := NewPlainReporter(&buf)
+
+	s := &Step{Args: []string{"go", "test"}, Retries: 1, Attempt: 1}
+	r.OnStart(s)
+	s.Err = errors.New("exit status 1")
+	s.Output = []byte("FAIL\n")
+	s.Retrying = true
+	r.OnFinish(s)
+
+	s.Attempt, s.Err, s.Output, s.Retrying = 2, nil, nil, false
+	r.OnStart(s)
+	r.OnFinish(s)
+
+	e := "|In Progress| go test\r|Retrying   |\nFAIL\n" +
+		"|In Progress| go test (attempt 2 of 2)\r|Passed     |\n"
+	assert.Equal(t, e, buf.String())
+}
+
+func TestPlainReporterWarning(t *testing.T) {
+	var buf bytes.Buffer
+	r := NewPlainReporter(&buf)
+
+	s := &Step{Args: []string{"golint"}, AllowFailure: true, Output: []byte("exported func\n")}
+	r.OnStart(s)
+	s.Err = errors.New("exit status 1")
+	r.OnFinish(s)
+
+	assert.Equal(t, "|In Progress| golint\r|Warning    |\nexported func\n", buf.String())
+}
+
+func TestTextReporterAsync(t *testing.T) {
+	var buf bytes.Buffer
+	r := NewPlainReporter(&buf)
+
+	server := &Step{Name: "server", Args: []string{"./server"}, Async: true, Pid: 1}
+	db := &Step{Name: "db", Args: []string{"./db"}, Async: true, Pid: 2}
+	r.OnStart(server)
+	r.OnStart(db)
+	r.OnOutput(db, []byte("ready\n"))
+	r.OnOutput(server, []byte("listening\n"))
+	r.OnFinish(db)
+
+	e := "|In Progress| ./server -- process id: 1\n" +
+		"|In Progress| ./db -- process id: 2\n" +
+		"db     | ready\n" +
+		"server | listening\n" +
+		"|Passed     | ./db\n"
+	assert.Equal(t, e, buf.String())
+
+	// the next execution only lines up with its own labels
+	buf.Reset()
+	r.Reset()
+	r.OnStart(db)
+	r.OnOutput(db, []byte("ready\n"))
+	assert.Equal(t, "|In Progress| ./db -- process id: 2\ndb | ready\n", buf.String())
+}
+
+func TestTextReporterVerbose(t *testing.T) {
+	s := &Step{Args: []string{"echo"}, Output: []byte("hello\n")}
+
+	var buf bytes.Buffer
+	r := NewPlainReporter(&buf)
+	r.OnFinish(s)
+	assert.Equal(t, plainStatuses.passed, buf.String())
+
+	buf.Reset()
+	r.Verbose = true
+	r.OnFinish(s)
+	assert.Equal(t, plainStatuses.passed+"hello\n", buf.String())
+
+	buf.Reset()
+	r.SetVerbose(false)
+	r.OnFinish(s)
+	assert.Equal(t, plainStatuses.passed, buf.String())
+}
+
+func TestTextReporterParallel(t *testing.T) {
+	var buf bytes.Buffer
+	r := NewPlainReporter(&buf)
+
+	vet := &Step{Args: []string{"go", "vet"}, Parallel: true}
+	lint := &Step{Args: []string{"golint"}, Parallel: true, Output: []byte("bad name\n")}
+	r.OnStart(vet)
+	r.OnStart(lint)
+	r.OnFinish(vet)
+	lint.Err = errors.New("exit status 1")
+	r.OnFinish(lint)
+
+	e := "|In Progress| go vet\n" +
+		"|In Progress| golint\n" +
+		"|Passed     | go vet\n" +
+		"|Failed     | golint\n" +
+		"bad name\n"
+	assert.Equal(t, e, buf.String())
+}
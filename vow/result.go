@@ -0,0 +1,70 @@
+package vow
+
+import (
+	"context"
+	"time"
+)
+
+// Result is the record of a Vow's execution
+type Result struct {
+	// Steps holds a record of every command in the Vow in the order
+	// they were added. Async commands are recorded as they were when
+	// the Vow finished executing, they may still be running.
+	Steps []*Step
+
+	Start time.Time
+	End   time.Time
+
+	// Canceled is set when the Vow was stopped, or the context
+	// it was executed with was canceled, while executing
+	Canceled bool
+
+	// Err holds the error of the context the Vow was executed
+	// with if it was done before the Vow finished
+	Err error
+
+	// Vars holds what the commands registered by name, along
+	// with the Vars the Vow was executed with
+	Vars map[string]string
+}
+
+// TimedOut reports whether the Vow did not finish before
+// the deadline of the context it was executed with
+func (r *Result) TimedOut() bool {
+	return r.Err == context.DeadlineExceeded
+}
+
+// Passed reports whether every command ran and was successful. Commands
+// that were allowed to fail and the ones that only run after a failure
+// don't count.
+func (r *Result) Passed() bool {
+	if r.Canceled || r.Err != nil {
+		return false
+	}
+
+	for _, s := range r.Steps {
+		switch {
+		case s.Passed(), s.Warned():
+		case s.OnFailure && s.Skipped:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Failed returns the first step that failed, and was not
+// allowed to, or nil if none did
+func (r *Result) Failed() *Step {
+	for _, s := range r.Steps {
+		if !s.Skipped && !s.Canceled && s.Err != nil && !s.AllowFailure {
+			return s
+		}
+	}
+	return nil
+}
+
+// Duration returns how long the Vow took to execute
+func (r *Result) Duration() time.Duration {
+	return r.End.Sub(r.Start)
+}
@@ -1,64 +1,353 @@
 /*
 Package vow provides a promise like api for executing
 a batch of external commands
+
+Go code can be mixed in with the commands with ThenFunc, it is
+reported and recorded just like a command.
+
+The progress of a Vow is given to a Reporter as it executes.
+NewReporter writes it as colored status lines while NewPlainReporter
+does the same without any colors.
+
+	v := vow.To("go", "vet").Then("go", "test", "./...")
+	result := v.Exec(vow.NewReporter(os.Stdout))
+	if s := result.Failed(); s != nil {
+		fmt.Println("failed running", s.Command())
+	}
 */
 package vow
 
 import (
+	"context"
 	"io"
+	"sort"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // Vow represents a batch of commands being prepared to run
 type Vow struct {
-	canceled *int32
+	canceled   int32
+	terminated int32
+
+	// running is the reporter of an Exec in progress
+	mtx          sync.Mutex
+	running      Reporter
+	cancelReport sync.Once
+
+	tasks []task
+
+	// Stream reports the output of commands as it is written
+	// instead of waiting for them to finish
+	Stream bool
+
+	// LogDir is where the full output of every command is written,
+	// in a file named after the command, when it is set
+	LogDir string
+
+	// TTY runs every command under a pseudo-terminal
+	TTY bool
+
+	// Vars are values the commands can use as if they had been
+	// registered before the Vow executed, like the Vars of the
+	// Result of an earlier Vow when only some of it runs again
+	Vars map[string]string
+}
+
+// task is a single step of a Vow
+type task interface {
+	Run(r Reporter, opts options) error
 
-	cmds    []*promise
-	Verbose bool
+	// kill terminates the task and silences it
+	kill()
+	terminate()
+
+	// wait blocks until everything the task started has exited
+	wait()
+
+	// records returns a copy of the record of every step in the task
+	records() []*Step
+
+	// registers returns the names the task registers output under
+	registers() []string
+
+	// runs reports whether the task should run when a
+	// task before it failed, or when none did
+	runs(failed bool) bool
 }
 
 // To returns a new Vow that is configured to execute command given.
 func To(name string, args ...string) *Vow {
 	return &Vow{
-		cmds:     []*promise{newPromise(name, args...)},
-		canceled: new(int32),
+		tasks: []task{newPromise(name, args...)},
 	}
 }
 
 // Then adds the given command to the list of commands the Vow will execute
 func (vow *Vow) Then(name string, args ...string) *Vow {
-	vow.cmds = append(vow.cmds, newPromise(name, args...))
+	vow.tasks = append(vow.tasks, newPromise(name, args...))
 	return vow
 }
 
+// ThenAsync adds the given command to the list of commands the Vow will execute
+// without waiting for it to finish. Its output is forwarded line by line as it
+// is written.
 func (vow *Vow) ThenAsync(name string, args ...string) *Vow {
-	vow.cmds = append(vow.cmds, newAsyncPromise(name, args...))
+	vow.tasks = append(vow.tasks, newAsyncPromise(name, args...))
+	return vow
+}
+
+// ThenFunc adds fn to the list of commands the Vow will execute. It is
+// reported and recorded under name like any other command with everything
+// it writes to out as its output. The context given to fn is derived from
+// the one given to ExecContext and is canceled when the Vow is stopped, fn
+// is expected to return soon after. A panic in fn fails the command.
+func (vow *Vow) ThenFunc(name string, fn func(ctx context.Context, out io.Writer) error) *Vow {
+	vow.tasks = append(vow.tasks, newFuncPromise(name, fn))
+	return vow
+}
+
+// ThenAll adds the given Vows to the list of commands the Vow will execute.
+// They are all executed at the same time and the Vow moves on once every one
+// of them is done. If any of them fails, the Vow fails.
+func (vow *Vow) ThenAll(vows ...*Vow) *Vow {
+	vow.tasks = append(vow.tasks, newGroup(vows))
 	return vow
 }
 
-// Stop terminates the active command and stops the execution of any future commands
+// As names the last command added to the Vow. The name is used
+// instead of the command to identify the command's output.
+func (vow *Vow) As(name string) *Vow {
+	if p, ok := vow.last().(*promise); ok {
+		p.name = name
+	}
+	return vow
+}
+
+// Cache lets the last command added to the Vow be skipped when
+// c reports that the work it does has already been done
+func (vow *Vow) Cache(c Cache) *Vow {
+	if p, ok := vow.last().(*promise); ok && !p.async {
+		p.cache = c
+	}
+	return vow
+}
+
+// Retry lets the last command added to the Vow run up to n more times
+// when it fails, waiting for delay before each new attempt
+func (vow *Vow) Retry(n int, delay time.Duration) *Vow {
+	if p, ok := vow.last().(*promise); ok && !p.async {
+		p.retries = n
+		p.retryDelay = delay
+	}
+	return vow
+}
+
+// Register saves the output the last command added to the Vow writes to
+// stdout, without any leading or trailing space, under name once it passes.
+// Commands after it can use it in their arguments as $$name or ${name} and
+// read it from the environment variable name.
+func (vow *Vow) Register(name string) *Vow {
+	if p, ok := vow.last().(*promise); ok && !p.async {
+		p.register = name
+	}
+	return vow
+}
+
+// Terminal runs the last command added to the Vow under a pseudo-terminal
+// so that it behaves as it would in a shell, its output is still reported
+// as usual
+func (vow *Vow) Terminal() *Vow {
+	if p, ok := vow.last().(*promise); ok && p.fn == nil {
+		p.tty = true
+	}
+	return vow
+}
+
+// AllowFailure lets the Vow go on when the last command added to it fails.
+// The failure of the command does not fail the Vow.
+func (vow *Vow) AllowFailure() *Vow {
+	if p, ok := vow.last().(*promise); ok {
+		p.allowFailure = true
+	}
+	return vow
+}
+
+// Always makes the last command added to the Vow run even
+// when a command before it failed
+func (vow *Vow) Always() *Vow {
+	if p, ok := vow.last().(*promise); ok {
+		p.when = whenAlways
+	}
+	return vow
+}
+
+// OnFailure makes the last command added to the Vow run only
+// when a command before it failed, to clean up after it
+func (vow *Vow) OnFailure() *Vow {
+	if p, ok := vow.last().(*promise); ok {
+		p.when = whenFailed
+	}
+	return vow
+}
+
+// Limit sets the maximum number of Vows that are executed at
+// the same time by the last ThenAll added to the Vow
+func (vow *Vow) Limit(n int) *Vow {
+	if g, ok := vow.last().(*group); ok {
+		g.limit = n
+	}
+	return vow
+}
+
+func (vow *Vow) last() task {
+	if len(vow.tasks) == 0 {
+		return nil
+	}
+	return vow.tasks[len(vow.tasks)-1]
+}
+
+// Stop terminates the active command and stops the execution of any future
+// commands. It blocks until every command the Vow started, async ones
+// included, has exited. Nothing is reported about the commands once
+// the Vow has been stopped.
 func (vow *Vow) Stop() {
-	atomic.StoreInt32(vow.canceled, 1)
-	for i := 0; i < len(vow.cmds); i++ {
-		vow.cmds[i].kill()
+	vow.kill()
+	vow.reportCancel()
+	vow.wait()
+}
+
+func (vow *Vow) kill() {
+	atomic.StoreInt32(&vow.canceled, 1)
+	for _, t := range vow.tasks {
+		t.kill()
+	}
+}
+
+func (vow *Vow) terminate() {
+	atomic.StoreInt32(&vow.terminated, 1)
+	for _, t := range vow.tasks {
+		t.terminate()
+	}
+}
+
+func (vow *Vow) wait() {
+	for _, t := range vow.tasks {
+		t.wait()
 	}
 }
 
 func (vow *Vow) isCanceled() bool {
-	return atomic.LoadInt32(vow.canceled) == 1
+	return atomic.LoadInt32(&vow.canceled) == 1
+}
+
+func (vow *Vow) isTerminated() bool {
+	return vow.isCanceled() || atomic.LoadInt32(&vow.terminated) == 1
+}
+
+// reportCancel lets the reporter of an Exec in progress know
+// that it was canceled, at most once
+func (vow *Vow) reportCancel() {
+	vow.mtx.Lock()
+	if r := vow.running; r != nil {
+		vow.cancelReport.Do(r.OnCancel)
+	}
+	vow.mtx.Unlock()
 }
 
-// Exec runs all of the commands a Vow has with all output redirected
-// to the given writer and returns a Result
-func (vow *Vow) Exec(w io.Writer) bool {
-	for i := 0; i < len(vow.cmds); i++ {
-		if vow.isCanceled() {
-			return false
+// run runs each task in order. Once one of them fails only
+// the tasks that are meant to run after a failure are run.
+func (vow *Vow) run(r Reporter, opts options) error {
+	var failed error
+	for _, t := range vow.tasks {
+		if vow.isTerminated() {
+			return errKilled
+		}
+
+		if !t.runs(failed != nil) {
+			continue
 		}
 
-		if err := vow.cmds[i].Run(w, vow.Verbose); err != nil {
-			return false
+		if err := t.Run(r, opts); err != nil && failed == nil {
+			failed = err
 		}
 	}
-	return true
+	return failed
+}
+
+func (vow *Vow) records() []*Step {
+	var steps []*Step
+	for _, t := range vow.tasks {
+		steps = append(steps, t.records()...)
+	}
+	return steps
+}
+
+func (vow *Vow) registers() []string {
+	var names []string
+	for _, t := range vow.tasks {
+		names = append(names, t.registers()...)
+	}
+	return names
+}
+
+// Exec runs all of the commands a Vow has, reporting their progress
+// to r, and returns a Result with a record of each of them
+func (vow *Vow) Exec(r Reporter) *Result {
+	return vow.ExecContext(context.Background(), r)
+}
+
+// ExecContext is like Exec but the execution is bound to ctx. If ctx is
+// done before every command has run, the command that is running is
+// terminated and the remaining ones are skipped. Async commands that
+// were started are terminated as well while the Vow is executing but
+// are left alone once ExecContext has returned.
+func (vow *Vow) ExecContext(ctx context.Context, r Reporter) *Result {
+	vow.mtx.Lock()
+	vow.running = r
+	vow.mtx.Unlock()
+	defer func() {
+		vow.mtx.Lock()
+		vow.running = nil
+		vow.mtx.Unlock()
+	}()
+
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			vow.terminate()
+			vow.reportCancel()
+		case <-finished:
+		}
+	}()
+
+	res := &Result{Start: time.Now()}
+	vars := newVars(vow.registers())
+	names := make([]string, 0, len(vow.Vars))
+	for name := range vow.Vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		vars.set(name, vow.Vars[name])
+	}
+
+	if ctx.Err() == nil {
+		_ = vow.run(r, options{
+			ctx:    ctx,
+			stream: vow.Stream,
+			vars:   vars,
+			logs:   newLogDir(vow.LogDir),
+			tty:    vow.TTY,
+		})
+	}
+	res.End = time.Now()
+	res.Vars = vars.registered()
+	res.Err = ctx.Err()
+	res.Canceled = vow.isCanceled() || res.Err == context.Canceled
+	res.Steps = vow.records()
+	return res
 }
@@ -2,9 +2,15 @@ package vow
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -15,6 +21,9 @@ import (
 var (
 	echoScript = "../fixtures/echo.sh"
 	failScript = "../fixtures/fail.sh"
+
+	// colored are the statuses written by NewReporter
+	colored = coloredStatuses()
 )
 
 func TestTo(t *testing.T) {
@@ -23,9 +32,10 @@ func TestTo(t *testing.T) {
 	vow := To(cmd, args...)
 	require.NotNil(t, vow)
 
-	assert.Len(t, vow.cmds, 1)
-	assert.Equal(t, cmd, vow.cmds[0].cmd.Path)
-	assert.Equal(t, args, vow.cmds[0].cmd.Args[1:])
+	require.Len(t, vow.tasks, 1)
+	p := vow.tasks[0].(*promise)
+	assert.Equal(t, cmd, p.cmd.Path)
+	assert.Equal(t, args, p.cmd.Args[1:])
 }
 
 func TestThen(t *testing.T) {
@@ -36,15 +46,60 @@ func TestThen(t *testing.T) {
 	}
 	vow.Then("foo").Then("another")
 
-	assert.Len(t, vow.cmds, totalCmds+2)
+	assert.Len(t, vow.tasks, totalCmds+2)
 }
 
 func TestThenAsync(t *testing.T) {
 	var vow Vow
 	vow.ThenAsync("foo", "bar", "baz")
 
-	require.Len(t, vow.cmds, 1)
-	assert.True(t, vow.cmds[0].async)
+	require.Len(t, vow.tasks, 1)
+	assert.True(t, vow.tasks[0].(*promise).async)
+}
+
+func TestAs(t *testing.T) {
+	vow := To("foo").As("first")
+	vow.ThenAsync("bar", "baz")
+
+	require.Len(t, vow.tasks, 2)
+	assert.Equal(t, "first", vow.tasks[0].(*promise).label())
+	assert.Equal(t, "bar baz", vow.tasks[1].(*promise).label())
+
+	vow.As("second")
+	assert.Equal(t, "second", vow.tasks[1].(*promise).label())
+}
+
+// fakeCache is a Cache that is valid once it has been saved
+type fakeCache struct {
+	valid bool
+	saves int
+}
+
+func (c *fakeCache) Valid() bool { return c.valid }
+
+func (c *fakeCache) Save() {
+	c.saves++
+	c.valid = true
+}
+
+func TestCache(t *testing.T) {
+	c := &fakeCache{}
+	fail := &fakeCache{}
+	vow := To(echoScript).Cache(c).Then(failScript).Cache(fail)
+
+	res := vow.Exec(NewReporter(ioutil.Discard))
+	require.Len(t, res.Steps, 2)
+	assert.False(t, res.Steps[0].Cached)
+	assert.Equal(t, 1, c.saves)
+	assert.Equal(t, 0, fail.saves)
+
+	vow = To(echoScript).Cache(c).Then(failScript).Cache(fail)
+	res = vow.Exec(NewReporter(ioutil.Discard))
+	require.Len(t, res.Steps, 2)
+	assert.True(t, res.Steps[0].Cached)
+	assert.True(t, res.Steps[0].Passed())
+	assert.Equal(t, 1, c.saves)
+	assert.False(t, res.Steps[1].Cached)
 }
 
 func TestStop(t *testing.T) {
@@ -59,7 +114,7 @@ func TestStop(t *testing.T) {
 	started := make(chan struct{})
 	go func() {
 		close(started)
-		result <- vow.Exec(ioutil.Discard)
+		result <- vow.Exec(NewReporter(ioutil.Discard)).Passed()
 	}()
 	<-started
 
@@ -74,15 +129,14 @@ func TestStopAsync(t *testing.T) {
 	vow := To(echoScript)
 	vow.ThenAsync(echoScript)
 
-	require.True(t, vow.Exec(ioutil.Discard))
+	require.True(t, vow.Exec(NewReporter(ioutil.Discard)).Passed())
 	<-time.After(10 * time.Millisecond)
 
 	vow.Stop()
-	for _, p := range vow.cmds {
-		p.cmdMtx.Lock()
-		p.cmd.Wait()
+	for _, task := range vow.tasks {
+		p := task.(*promise)
+		<-p.done
 		assert.True(t, p.cmd.ProcessState.Exited())
-		p.cmdMtx.Unlock()
 	}
 }
 
@@ -91,19 +145,19 @@ func TestExec(t *testing.T) {
 
 	vow := To(echoScript)
 	vow.Then(echoScript)
-	result := vow.Exec(&testBuf)
+	result := vow.Exec(NewReporter(&testBuf))
 
 	e := fmt.Sprintf(
 		"%s %s%s%s %s%s",
-		statusInProgress,
+		colored.inProgress,
 		echoScript,
-		statusPassed,
-		statusInProgress,
+		colored.passed,
+		colored.inProgress,
 		echoScript,
-		statusPassed,
+		colored.passed,
 	)
 	assert.Equal(t, e, testBuf.String())
-	assert.True(t, result)
+	assert.True(t, result.Passed())
 }
 
 func TestExecCmdNotFound(t *testing.T) {
@@ -112,19 +166,19 @@ func TestExecCmdNotFound(t *testing.T) {
 	vow := To(echoScript)
 	vow.Then("asdfasdf", "asdas")
 	vow.Then("Shoud", "never", "happen")
-	result := vow.Exec(&testBuf)
+	result := vow.Exec(NewReporter(&testBuf))
 
 	e := fmt.Sprintf(
 		"%s %s%s%s asdfasdf asdas%sexec: \"asdfasdf\": executable file not found in ",
-		statusInProgress,
+		colored.inProgress,
 		echoScript,
-		statusPassed,
-		statusInProgress,
-		statusFailed,
+		colored.passed,
+		colored.inProgress,
+		colored.failed,
 	)
 
 	assert.True(t, strings.HasPrefix(testBuf.String(), e))
-	assert.False(t, result)
+	assert.False(t, result.Passed())
 }
 
 func TestExecCmdFailed(t *testing.T) {
@@ -133,35 +187,589 @@ func TestExecCmdFailed(t *testing.T) {
 	vow := To(echoScript)
 	vow.Then(failScript)
 	vow.Then("Shoud", "never", "happen")
-	result := vow.Exec(&testBuf)
+	result := vow.Exec(NewReporter(&testBuf))
 
 	e := fmt.Sprintf(
 		"%s %s%s%s %s%s",
-		statusInProgress,
+		colored.inProgress,
 		echoScript,
-		statusPassed,
-		statusInProgress,
+		colored.passed,
+		colored.inProgress,
 		failScript,
-		statusFailed,
+		colored.failed,
 	)
 
 	assert.Equal(t, e, testBuf.String())
-	assert.False(t, result)
+	assert.False(t, result.Passed())
 }
 
 func TestVowVerbose(t *testing.T) {
 	var testBuf bytes.Buffer
 
 	vow := To(echoScript)
-	vow.Verbose = true
-	result := vow.Exec(&testBuf)
+	r := NewReporter(&testBuf)
+	r.Verbose = true
+	result := vow.Exec(r)
 	e := fmt.Sprintf(
 		"%s %s%shello\r\n",
-		statusInProgress,
+		colored.inProgress,
+		echoScript,
+		colored.passed,
+	)
+
+	assert.Equal(t, e, testBuf.String())
+	assert.True(t, result.Passed())
+}
+
+func TestExecAsyncOutput(t *testing.T) {
+	testBuf := newSyncBuffer(1 << 20)
+
+	vow := To(echoScript)
+	vow.ThenAsync(echoScript).As("echo")
+	require.True(t, vow.Exec(NewReporter(testBuf)).Passed())
+
+	<-vow.tasks[1].(*promise).done
+	assert.Contains(t, string(testBuf.Bytes()), "echo | hello\r\n")
+}
+
+func TestVowStream(t *testing.T) {
+	var testBuf bytes.Buffer
+
+	vow := To(echoScript)
+	vow.Then(failScript)
+	vow.Stream = true
+	result := vow.Exec(NewReporter(&testBuf))
+
+	passed := colored.passed[1 : len(colored.passed)-1]
+	failed := colored.failed[1 : len(colored.failed)-1]
+	e := fmt.Sprintf(
+		"%s %s\nhello\r\n%s %s\n%s %s\n%s %s\n",
+		colored.inProgress,
+		echoScript,
+		passed,
 		echoScript,
-		statusPassed,
+		colored.inProgress,
+		failScript,
+		failed,
+		failScript,
 	)
 
 	assert.Equal(t, e, testBuf.String())
-	assert.True(t, result)
+	assert.False(t, result.Passed())
+}
+
+type recordReporter struct {
+	mtx      sync.Mutex
+	started  []*Step
+	finished []*Step
+	output   []string
+	canceled bool
+}
+
+func (r *recordReporter) OnStart(s *Step) {
+	r.mtx.Lock()
+	r.started = append(r.started, s)
+	r.mtx.Unlock()
+}
+
+func (r *recordReporter) OnOutput(s *Step, line []byte) {
+	r.mtx.Lock()
+	r.output = append(r.output, string(line))
+	r.mtx.Unlock()
+}
+
+func (r *recordReporter) OnFinish(s *Step) {
+	r.mtx.Lock()
+	r.finished = append(r.finished, s)
+	r.mtx.Unlock()
+}
+
+func (r *recordReporter) OnCancel() {
+	r.mtx.Lock()
+	r.canceled = true
+	r.mtx.Unlock()
+}
+
+func TestExecReporter(t *testing.T) {
+	var r recordReporter
+
+	vow := To(echoScript)
+	vow.Then(failScript)
+	require.False(t, vow.Exec(&r).Passed())
+
+	require.Len(t, r.started, 2)
+	require.Len(t, r.finished, 2)
+	assert.Empty(t, r.output)
+	assert.False(t, r.canceled)
+
+	echo := r.finished[0]
+	assert.Equal(t, echoScript, echo.Command())
+	assert.True(t, echo.Passed())
+	assert.Equal(t, 0, echo.ExitCode)
+	assert.Equal(t, "hello\r\n", string(echo.Output))
+	assert.True(t, echo.Pid > 0)
+	assert.False(t, echo.End.Before(echo.Start))
+
+	fail := r.finished[1]
+	assert.False(t, fail.Passed())
+	assert.Equal(t, 1, fail.ExitCode)
+}
+
+func TestExecReporterStream(t *testing.T) {
+	var r recordReporter
+
+	vow := To(echoScript)
+	vow.Stream = true
+	require.True(t, vow.Exec(&r).Passed())
+
+	assert.Equal(t, []string{"hello\r\n"}, r.output)
+}
+
+func TestStopReportsCancel(t *testing.T) {
+	var r recordReporter
+
+	vow := To("sleep", "1")
+
+	done := make(chan bool)
+	go func() { done <- vow.Exec(&r).Passed() }()
+	for {
+		r.mtx.Lock()
+		n := len(r.started)
+		r.mtx.Unlock()
+		if n > 0 {
+			break
+		}
+		<-time.After(time.Millisecond)
+	}
+
+	vow.Stop()
+	assert.False(t, <-done)
+	assert.True(t, r.canceled)
+}
+
+func TestExecResult(t *testing.T) {
+	vow := To(echoScript).As("echo")
+	vow.Then(failScript)
+	vow.Then("Shoud", "never", "happen")
+	res := vow.Exec(NewReporter(ioutil.Discard))
+
+	require.Len(t, res.Steps, 3)
+	assert.False(t, res.Passed())
+	assert.False(t, res.Canceled)
+	assert.False(t, res.End.Before(res.Start))
+
+	echo := res.Steps[0]
+	assert.Equal(t, "echo", echo.Name)
+	assert.True(t, echo.Passed())
+	assert.Equal(t, "hello\r\n", string(echo.Output))
+
+	fail := res.Steps[1]
+	assert.Equal(t, fail, res.Failed())
+	assert.Equal(t, failScript, fail.Command())
+	assert.Equal(t, 1, fail.ExitCode)
+	assert.Empty(t, fail.Signal)
+
+	never := res.Steps[2]
+	assert.True(t, never.Skipped)
+	assert.False(t, never.Canceled)
+	assert.False(t, never.Passed())
+	assert.Equal(t, time.Duration(0), never.Duration())
+}
+
+func TestExecResultCanceled(t *testing.T) {
+	var r recordReporter
+
+	vow := To("sleep", "1")
+	vow.Then(echoScript)
+
+	done := make(chan *Result)
+	go func() { done <- vow.Exec(&r) }()
+	for {
+		r.mtx.Lock()
+		n := len(r.started)
+		r.mtx.Unlock()
+		if n > 0 {
+			break
+		}
+		<-time.After(time.Millisecond)
+	}
+
+	vow.Stop()
+	res := <-done
+	require.Len(t, res.Steps, 2)
+	assert.True(t, res.Canceled)
+	assert.Nil(t, res.Failed())
+
+	sleep := res.Steps[0]
+	assert.True(t, sleep.Canceled)
+	assert.Equal(t, -1, sleep.ExitCode)
+	assert.Equal(t, "terminated", sleep.Signal)
+
+	assert.True(t, res.Steps[1].Canceled)
+	assert.False(t, res.Steps[1].Skipped)
+}
+
+func TestExecContextCanceled(t *testing.T) {
+	var r recordReporter
+
+	vow := To("sleep", "5")
+	vow.Then(echoScript)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-time.After(50 * time.Millisecond)
+		cancel()
+	}()
+
+	start := time.Now()
+	res := vow.ExecContext(ctx, &r)
+	assert.True(t, time.Since(start) < 5*time.Second, "command was not terminated")
+
+	assert.True(t, res.Canceled)
+	assert.False(t, res.TimedOut())
+	assert.Equal(t, context.Canceled, res.Err)
+	assert.True(t, r.canceled)
+
+	// the terminated command is still reported
+	require.Len(t, r.finished, 1)
+	assert.True(t, r.finished[0].Canceled)
+	assert.True(t, res.Steps[1].Canceled)
+}
+
+func TestExecContextDeadline(t *testing.T) {
+	vow := To("sleep", "5")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	res := vow.ExecContext(ctx, NewReporter(ioutil.Discard))
+	assert.True(t, res.TimedOut())
+	assert.False(t, res.Canceled)
+	assert.False(t, res.Passed())
+}
+
+func TestStopWaitsForExit(t *testing.T) {
+	vow := To(echoScript)
+	vow.ThenAsync("sleep", "5")
+	require.True(t, vow.Exec(NewReporter(ioutil.Discard)).Passed())
+
+	vow.Stop()
+	p := vow.tasks[1].(*promise)
+	select {
+	case <-p.done:
+	default:
+		t.Fatal("Stop returned before the async command exited")
+	}
+}
+
+func TestStopKillsAfterTimeout(t *testing.T) {
+	defer func(d time.Duration) { killTimeout = d }(killTimeout)
+	killTimeout = 50 * time.Millisecond
+
+	vow := To(echoScript)
+	vow.ThenAsync("sh", "-c", "trap '' TERM; sleep 5")
+	require.True(t, vow.Exec(NewReporter(ioutil.Discard)).Passed())
+	// give the shell a chance to set up the trap
+	<-time.After(50 * time.Millisecond)
+
+	start := time.Now()
+	vow.Stop()
+	assert.True(t, time.Since(start) < 5*time.Second, "command was not killed")
+}
+
+func TestRetry(t *testing.T) {
+	f, err := ioutil.TempFile("", "snag-retry")
+	require.NoError(t, err)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	// fails the first time it runs and passes after that
+	flaky := fmt.Sprintf("if [ -s %[1]s ]; then exit 0; fi; echo ran > %[1]s; exit 1", f.Name())
+
+	var r recordReporter
+	vow := To("sh", "-c", flaky).Retry(2, 10*time.Millisecond)
+	res := vow.Exec(&r)
+	assert.True(t, res.Passed())
+
+	require.Len(t, res.Steps, 1)
+	assert.Equal(t, 2, res.Steps[0].Attempt)
+	assert.Equal(t, 2, res.Steps[0].Retries)
+	assert.True(t, res.Steps[0].Retried())
+	assert.False(t, res.Steps[0].Retrying)
+	assert.Len(t, r.started, 2)
+	assert.Len(t, r.finished, 2)
+}
+
+func TestRetryFailed(t *testing.T) {
+	vow := To(failScript).Retry(2, 0).Then(echoScript)
+	res := vow.Exec(NewReporter(ioutil.Discard))
+	assert.False(t, res.Passed())
+
+	require.Len(t, res.Steps, 2)
+	assert.Equal(t, 3, res.Steps[0].Attempt)
+	assert.Equal(t, 1, res.Steps[0].ExitCode)
+	assert.True(t, res.Steps[1].Skipped)
+}
+
+func TestStopWhileRetrying(t *testing.T) {
+	vow := To(failScript).Retry(1, 5*time.Second)
+
+	done := make(chan *Result)
+	go func() { done <- vow.Exec(NewReporter(ioutil.Discard)) }()
+	<-time.After(100 * time.Millisecond)
+
+	start := time.Now()
+	vow.Stop()
+	assert.True(t, time.Since(start) < 5*time.Second, "retry was not stopped")
+
+	res := <-done
+	assert.Equal(t, 1, res.Steps[0].Attempt)
+	assert.True(t, res.Steps[0].Canceled)
+}
+
+func TestAllowFailure(t *testing.T) {
+	vow := To(failScript).AllowFailure().Then(echoScript)
+	res := vow.Exec(NewReporter(ioutil.Discard))
+	assert.True(t, res.Passed())
+	assert.Nil(t, res.Failed())
+
+	require.Len(t, res.Steps, 2)
+	assert.True(t, res.Steps[0].Warned())
+	assert.True(t, res.Steps[1].Passed())
+}
+
+func TestAlways(t *testing.T) {
+	vow := To(failScript).Then(echoScript).Then(echoScript).Always()
+	res := vow.Exec(NewReporter(ioutil.Discard))
+	assert.False(t, res.Passed())
+
+	require.Len(t, res.Steps, 3)
+	assert.True(t, res.Steps[1].Skipped)
+	assert.True(t, res.Steps[2].Passed())
+}
+
+func TestOnFailure(t *testing.T) {
+	vow := To(echoScript).Then(echoScript).OnFailure()
+	res := vow.Exec(NewReporter(ioutil.Discard))
+	assert.True(t, res.Passed())
+	require.Len(t, res.Steps, 2)
+	assert.True(t, res.Steps[1].Skipped)
+
+	vow = To(failScript).Then(echoScript).OnFailure()
+	res = vow.Exec(NewReporter(ioutil.Discard))
+	assert.False(t, res.Passed())
+	require.Len(t, res.Steps, 2)
+	assert.True(t, res.Steps[1].Passed())
+	assert.Equal(t, res.Steps[0], res.Failed())
+}
+
+func TestRegister(t *testing.T) {
+	var r recordReporter
+	vow := To("echo", " abc123 ").Register("REV")
+	vow.Then("sh", "-c", `echo "$1 ${REV} $REV"`, "sh", "$$REV")
+	vow.Then("echo", "${MISSING}")
+	require.True(t, vow.Exec(&r).Passed())
+
+	require.Len(t, r.finished, 3)
+	assert.Equal(t, "abc123 abc123 abc123\n", string(r.finished[1].Output))
+	assert.Equal(t, []string{"sh", "-c", `echo "$1 abc123 $REV"`, "sh", "abc123"}, r.finished[1].Args)
+	assert.Equal(t, "${MISSING}\n", string(r.finished[2].Output))
+}
+
+func TestExecVars(t *testing.T) {
+	vow := To("echo", "$$REV").Register("OUT")
+	vow.Vars = map[string]string{"REV": "abc123"}
+	res := vow.Exec(NewReporter(ioutil.Discard))
+
+	require.True(t, res.Passed())
+	assert.Equal(t, "abc123\n", string(res.Steps[0].Output))
+	assert.Equal(t, map[string]string{"REV": "abc123", "OUT": "abc123"}, res.Vars)
+}
+
+func TestRegisterFailed(t *testing.T) {
+	vow := To(failScript).Register("REV").AllowFailure()
+	vow.Then("echo", "$$REV")
+	res := vow.Exec(NewReporter(ioutil.Discard))
+
+	require.Len(t, res.Steps, 2)
+	assert.False(t, res.Passed())
+	assert.Equal(t, res.Steps[1], res.Failed())
+	assert.EqualError(t, res.Steps[1].Err, "REV has no value, the command registering it did not pass")
+}
+
+func TestRegisterTooLarge(t *testing.T) {
+	vow := To("sh", "-c", "head -c 70000 /dev/zero | tr '\\0' a").Register("BIG")
+	vow.Then("echo", "$$BIG")
+	res := vow.Exec(NewReporter(ioutil.Discard))
+
+	require.Len(t, res.Steps, 2)
+	assert.Equal(t, res.Steps[0], res.Failed())
+	assert.EqualError(t, res.Steps[0].Err, "the output is too large to register as BIG, it can be at most 65536 bytes")
+	assert.True(t, res.Steps[1].Skipped)
+}
+
+func TestThenFunc(t *testing.T) {
+	var r recordReporter
+	vow := To(echoScript).ThenFunc("check", func(ctx context.Context, out io.Writer) error {
+		fmt.Fprintln(out, "all good")
+		return nil
+	}).Register("CHECK")
+	vow.Then("echo", "$$CHECK")
+
+	res := vow.Exec(&r)
+	assert.True(t, res.Passed())
+	require.Len(t, res.Steps, 3)
+	assert.Equal(t, "check", res.Steps[1].Label())
+	assert.Equal(t, "check", res.Steps[1].Command())
+	assert.Equal(t, "all good\n", string(res.Steps[1].Output))
+	assert.Equal(t, "all good\n", string(res.Steps[2].Output))
+	assert.Len(t, r.started, 3)
+	assert.Len(t, r.finished, 3)
+}
+
+func TestThenFuncFailed(t *testing.T) {
+	vow := To(echoScript).ThenFunc("check", func(ctx context.Context, out io.Writer) error {
+		return errors.New("file is missing")
+	}).Then(echoScript)
+
+	res := vow.Exec(NewReporter(ioutil.Discard))
+	assert.False(t, res.Passed())
+	require.Len(t, res.Steps, 3)
+	assert.Equal(t, res.Steps[1], res.Failed())
+	assert.EqualError(t, res.Steps[1].Err, "file is missing")
+	assert.True(t, res.Steps[2].Skipped)
+}
+
+func TestThenFuncPanic(t *testing.T) {
+	vow := To(echoScript).ThenFunc("check", func(ctx context.Context, out io.Writer) error {
+		panic("nil map")
+	}).Then(echoScript)
+
+	res := vow.Exec(NewReporter(ioutil.Discard))
+	assert.False(t, res.Passed())
+	require.Len(t, res.Steps, 3)
+	assert.Equal(t, res.Steps[1], res.Failed())
+	assert.EqualError(t, res.Steps[1].Err, "panic: nil map")
+	assert.Contains(t, string(res.Steps[1].Output), "panic: nil map")
+	assert.True(t, res.Steps[2].Skipped)
+}
+
+func TestThenFuncContext(t *testing.T) {
+	type key struct{}
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), key{}, "value"), time.Minute)
+	defer cancel()
+
+	var value interface{}
+	var deadline bool
+	vow := To(echoScript).ThenFunc("check", func(ctx context.Context, out io.Writer) error {
+		value = ctx.Value(key{})
+		_, deadline = ctx.Deadline()
+		return nil
+	})
+
+	res := vow.ExecContext(ctx, NewReporter(ioutil.Discard))
+	assert.True(t, res.Passed())
+	assert.Equal(t, "value", value)
+	assert.True(t, deadline)
+}
+
+func TestStopFunc(t *testing.T) {
+	vow := To(echoScript).ThenFunc("wait", func(ctx context.Context, out io.Writer) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	done := make(chan *Result)
+	go func() { done <- vow.Exec(NewReporter(ioutil.Discard)) }()
+	<-time.After(100 * time.Millisecond)
+	vow.Stop()
+
+	res := <-done
+	assert.True(t, res.Canceled)
+	assert.True(t, res.Steps[1].Canceled)
+	assert.Equal(t, context.Canceled, res.Steps[1].Err)
+}
+
+func TestExecLogDir(t *testing.T) {
+	defer func(head, tail int) { outputHeadLines, outputTailLines = head, tail }(outputHeadLines, outputTailLines)
+	outputHeadLines, outputTailLines = 1, 1
+
+	dir, err := ioutil.TempDir("", "snag-logs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	vow := To("sh", "-c", "printf '1\n2\n3\n4\n'; exit 1")
+	vow.Then(echoScript).Always()
+	vow.Then(echoScript).Always()
+	vow.LogDir = dir
+	res := vow.Exec(NewReporter(ioutil.Discard))
+
+	require.Len(t, res.Steps, 3)
+	s := res.Steps[0]
+	assert.Equal(t, filepath.Join(dir, "sh_-c_printf_1_2_3_4_exit_1.log"), s.LogFile)
+	assert.Equal(t, 2, s.Truncated)
+	assert.Equal(t, "1\n... 2 lines truncated, see "+s.LogFile+"\n4\n", string(s.Output))
+
+	b, err := ioutil.ReadFile(s.LogFile)
+	require.NoError(t, err)
+	assert.Equal(t, "1\n2\n3\n4\n", string(b))
+
+	// commands with the same label get their own log
+	assert.NotEqual(t, res.Steps[1].LogFile, res.Steps[2].LogFile)
+	assert.NotEmpty(t, res.Steps[2].LogFile)
+}
+
+func TestTerminal(t *testing.T) {
+	vow := To("sh", "-c", "test -t 1 && echo tty").Terminal()
+	vow.Then("sh", "-c", "test -t 1 || echo pipe")
+	vow.Then("sh", "-c", "stty size").Terminal().Register("SIZE")
+	vow.Then("sh", "-c", "test -t 1 || exit 1")
+	vow.Terminal()
+	res := vow.Exec(NewReporter(ioutil.Discard))
+
+	require.Len(t, res.Steps, 4)
+	assert.Equal(t, "tty\n", string(res.Steps[0].Output))
+	assert.Equal(t, "pipe\n", string(res.Steps[1].Output))
+	assert.Regexp(t, `^\d+ \d+\n$`, string(res.Steps[2].Output))
+	assert.True(t, res.Steps[3].Passed())
+}
+
+// slowReporter takes a while for some of the lines it is given
+type slowReporter struct {
+	recordReporter
+	lines int
+}
+
+func (r *slowReporter) OnOutput(s *Step, line []byte) {
+	r.lines++
+	if r.lines%1000 == 0 {
+		time.Sleep(50 * time.Millisecond)
+	}
+	r.recordReporter.OnOutput(s, line)
+}
+
+func TestTerminalSlowReporter(t *testing.T) {
+	var r slowReporter
+	vow := To("sh", "-c", "seq 1 20000; echo LAST").Terminal()
+	vow.Stream = true
+	res := vow.Exec(&r)
+
+	require.True(t, res.Passed())
+	require.Len(t, r.output, 20001)
+	assert.Equal(t, "LAST\n", r.output[20000])
+}
+
+func TestTerminalBackground(t *testing.T) {
+	// the sleep keeps the terminal open after the command exited
+	vow := To("sh", "-c", "echo started; sleep 10 &").Terminal()
+	done := make(chan *Result)
+	go func() { done <- vow.Exec(NewReporter(ioutil.Discard)) }()
+
+	select {
+	case res := <-done:
+		assert.True(t, res.Passed())
+		assert.Equal(t, "started\n", string(res.Steps[0].Output))
+	case <-time.After(5 * time.Second):
+		t.Fatal("the command is still running")
+	}
 }
@@ -0,0 +1,24 @@
+// +build !linux,!darwin
+
+package vow
+
+import (
+	"errors"
+	"io"
+	"os/exec"
+)
+
+var errNoTerminal = errors.New("running commands in a terminal is not supported on this platform")
+
+// terminal is the pseudo-terminal a command runs under
+type terminal struct{}
+
+func openTerminal(cmd *exec.Cmd) (*terminal, error) {
+	return nil, errNoTerminal
+}
+
+func (t *terminal) start(w io.Writer) {}
+
+func (t *terminal) wait() {}
+
+func (t *terminal) close() {}
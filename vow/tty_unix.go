@@ -0,0 +1,167 @@
+// +build linux darwin
+
+package vow
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// ttyDrain is how long the terminal of a command that exited can go
+// without any output before something the command started is assumed
+// to be holding it open
+const ttyDrain = 100 * time.Millisecond
+
+// terminal is the pseudo-terminal a command runs under. reading is
+// set while the copy waits for output and progress is sent to
+// whenever it got some.
+type terminal struct {
+	master   *os.File
+	slave    *os.File
+	sigs     chan os.Signal
+	done     chan struct{}
+	reading  int32
+	progress chan struct{}
+}
+
+// openTerminal sets up cmd to run under a new pseudo-terminal
+// the size of the one snag is running in
+func openTerminal(cmd *exec.Cmd) (*terminal, error) {
+	master, slave, err := openPTY()
+	if err != nil {
+		return nil, err
+	}
+
+	t := &terminal{
+		master:   master,
+		slave:    slave,
+		sigs:     make(chan os.Signal, 1),
+		done:     make(chan struct{}),
+		progress: make(chan struct{}, 1),
+	}
+	t.resize()
+
+	// the output is not shown by a terminal right away
+	// so new lines don't need a carriage return
+	var tios syscall.Termios
+	if err := ioctl(slave.Fd(), ioctlGetTermios, uintptr(unsafe.Pointer(&tios))); err == nil {
+		tios.Oflag &^= syscall.ONLCR
+		_ = ioctl(slave.Fd(), ioctlSetTermios, uintptr(unsafe.Pointer(&tios)))
+	}
+
+	cmd.Stdin = slave
+	cmd.Stdout = slave
+	cmd.Stderr = slave
+	// the command leads its own session, and process group,
+	// so that the terminal can be its controlling terminal
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+	return t, nil
+}
+
+// start copies everything the command writes to w and keeps the size
+// of the terminal in sync with snag's, it is called right after the
+// command started
+func (t *terminal) start(w io.Writer) {
+	// only the command needs the slave now, so reading
+	// the master ends once the command closed it
+	t.slave.Close()
+
+	signal.Notify(t.sigs, syscall.SIGWINCH)
+	go func() {
+		for range t.sigs {
+			t.resize()
+		}
+	}()
+
+	go t.copy(w)
+}
+
+// copy writes everything read from the terminal to w, reading fails
+// once the command and anything it started have closed the terminal
+func (t *terminal) copy(w io.Writer) {
+	defer close(t.done)
+
+	buf := make([]byte, 32*1024)
+	for {
+		atomic.StoreInt32(&t.reading, 1)
+		n, err := t.master.Read(buf)
+		atomic.StoreInt32(&t.reading, 0)
+
+		select {
+		case t.progress <- struct{}{}:
+		default:
+		}
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// wait blocks until everything the command wrote has been copied, it is
+// called once the command exited. A process the command left behind, like
+// a daemon, can keep the terminal open so the copy is given up on once it
+// has been waiting for output for ttyDrain, however long writing takes.
+func (t *terminal) wait() {
+	idle := time.NewTimer(ttyDrain)
+	defer idle.Stop()
+
+	for {
+		select {
+		case <-t.done:
+			t.close()
+			return
+		case <-t.progress:
+		case <-idle.C:
+			if atomic.LoadInt32(&t.reading) == 1 {
+				t.close()
+				return
+			}
+		}
+
+		if !idle.Stop() {
+			select {
+			case <-idle.C:
+			default:
+			}
+		}
+		idle.Reset(ttyDrain)
+	}
+}
+
+func (t *terminal) close() {
+	signal.Stop(t.sigs)
+	close(t.sigs)
+	t.master.Close()
+	t.slave.Close()
+}
+
+// resize sets the size of the terminal to the size of the one snag
+// is running in, or to 80 columns and 24 rows if it has none
+func (t *terminal) resize() {
+	ws := struct{ row, col, x, y uint16 }{row: 24, col: 80}
+	for _, f := range []*os.File{os.Stdout, os.Stdin} {
+		if ioctl(f.Fd(), syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&ws))) == nil {
+			break
+		}
+	}
+	_ = ioctl(t.master.Fd(), syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(&ws)))
+}
+
+func ioctl(fd, req, arg uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, arg)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
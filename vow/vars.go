@@ -0,0 +1,110 @@
+package vow
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+// varPattern matches both $$NAME and ${NAME}
+var varPattern = regexp.MustCompile(`\$\$([A-Za-z_][A-Za-z0-9_]*)|\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// maxRegister is the most output a command can register, the
+// value has to fit in an argument or an environment variable
+const maxRegister = 64 * 1024
+
+// vars holds the output registered by the commands of a Vow
+// while it executes. A nil vars has nothing registered.
+// declared has the names the commands register under.
+type vars struct {
+	mtx      sync.RWMutex
+	values   map[string]string
+	names    []string
+	declared map[string]bool
+}
+
+func newVars(declared []string) *vars {
+	v := &vars{values: make(map[string]string), declared: make(map[string]bool)}
+	for _, name := range declared {
+		v.declared[name] = true
+	}
+	return v
+}
+
+func (v *vars) set(name, value string) {
+	if v == nil {
+		return
+	}
+
+	v.mtx.Lock()
+	if _, ok := v.values[name]; !ok {
+		v.names = append(v.names, name)
+	}
+	v.values[name] = value
+	v.mtx.Unlock()
+}
+
+// expand replaces the variables in args that have been registered.
+// It fails for a variable a command registers under that has no value,
+// because that command did not pass, while anything else is left as it is.
+func (v *vars) expand(args []string) ([]string, error) {
+	if v == nil {
+		return args, nil
+	}
+
+	v.mtx.RLock()
+	defer v.mtx.RUnlock()
+
+	var missing string
+	expanded := make([]string, len(args))
+	for i, a := range args {
+		expanded[i] = varPattern.ReplaceAllStringFunc(a, func(m string) string {
+			sub := varPattern.FindStringSubmatch(m)
+			name := sub[1] + sub[2]
+			if value, ok := v.values[name]; ok {
+				return value
+			}
+			if v.declared[name] && missing == "" {
+				missing = name
+			}
+			return m
+		})
+	}
+	if missing != "" {
+		return nil, fmt.Errorf("%s has no value, the command registering it did not pass", missing)
+	}
+	return expanded, nil
+}
+
+// registered returns a copy of the values registered so far by name
+func (v *vars) registered() map[string]string {
+	if v == nil {
+		return nil
+	}
+
+	v.mtx.RLock()
+	defer v.mtx.RUnlock()
+
+	values := make(map[string]string, len(v.values))
+	for name, value := range v.values {
+		values[name] = value
+	}
+	return values
+}
+
+// environ returns the registered variables in the
+// form of environment variables, nil if there are none
+func (v *vars) environ() []string {
+	if v == nil {
+		return nil
+	}
+
+	v.mtx.RLock()
+	defer v.mtx.RUnlock()
+
+	var env []string
+	for _, name := range v.names {
+		env = append(env, name+"="+v.values[name])
+	}
+	return env
+}
@@ -0,0 +1,188 @@
+package vow
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// failureSummaryLines is the amount of lines of output repeated
+// after a failed command whose output was streamed
+const failureSummaryLines = 20
+
+// maxLineLength is the amount of bytes a lineWriter will hold on to
+// before writing out a line that has not been terminated yet
+const maxLineLength = 4096
+
+// syncWriter serializes writes to the underlying writer so that
+// output from concurrent commands does not get interleaved
+type syncWriter struct {
+	mtx sync.Mutex
+	w   io.Writer
+}
+
+func newSyncWriter(w io.Writer) io.Writer {
+	if sw, ok := w.(*syncWriter); ok {
+		return sw
+	}
+	return &syncWriter{w: w}
+}
+
+func (sw *syncWriter) Write(p []byte) (int, error) {
+	sw.mtx.Lock()
+	n, err := sw.w.Write(p)
+	sw.mtx.Unlock()
+	return n, err
+}
+
+// lineWriter buffers everything written to it and hands
+// out complete lines to the write function
+type lineWriter struct {
+	mtx   sync.Mutex
+	buf   []byte
+	write func([]byte)
+}
+
+func newLineWriter(write func([]byte)) *lineWriter {
+	return &lineWriter{write: write}
+}
+
+func (lw *lineWriter) Write(p []byte) (int, error) {
+	lw.mtx.Lock()
+	defer lw.mtx.Unlock()
+
+	lw.buf = append(lw.buf, p...)
+	for {
+		i := bytes.IndexByte(lw.buf, '\n')
+		if i == -1 {
+			break
+		}
+
+		lw.writeLine(lw.buf[:i+1])
+		lw.buf = lw.buf[i+1:]
+	}
+
+	// don't hold on to a never ending line forever
+	if len(lw.buf) >= maxLineLength {
+		lw.writeLine(append(lw.buf, '\n'))
+		lw.buf = nil
+	}
+
+	return len(p), nil
+}
+
+// Flush writes out whatever is left in the buffer as a line
+func (lw *lineWriter) Flush() {
+	lw.mtx.Lock()
+	if len(lw.buf) > 0 {
+		lw.writeLine(append(lw.buf, '\n'))
+		lw.buf = nil
+	}
+	lw.mtx.Unlock()
+}
+
+func (lw *lineWriter) writeLine(line []byte) {
+	// the buffer is reused so hand out a copy
+	b := make([]byte, len(line))
+	copy(b, line)
+	lw.write(b)
+}
+
+// outputHeadLines and outputTailLines are the amount of lines of a
+// command's output that are held on to from its start and its end
+var (
+	outputHeadLines = 200
+	outputTailLines = 800
+)
+
+// capBuffer holds on to the first and the last lines written to it,
+// the ones in between are dropped once there are too many of them
+type capBuffer struct {
+	lw *lineWriter
+
+	mtx     sync.Mutex
+	head    []byte
+	nhead   int
+	tail    [][]byte
+	next    int
+	dropped int
+
+	// logFile is where all of the lines can be found
+	logFile string
+}
+
+func newCapBuffer(logFile string) *capBuffer {
+	b := &capBuffer{logFile: logFile}
+	b.lw = newLineWriter(b.addLine)
+	return b
+}
+
+func (b *capBuffer) Write(p []byte) (int, error) {
+	return b.lw.Write(p)
+}
+
+func (b *capBuffer) addLine(line []byte) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	switch {
+	case b.nhead < outputHeadLines:
+		b.head = append(b.head, line...)
+		b.nhead++
+	case len(b.tail) < outputTailLines:
+		b.tail = append(b.tail, line)
+	default:
+		// the tail is a ring, next is its oldest line
+		b.tail[b.next] = line
+		b.next = (b.next + 1) % len(b.tail)
+		b.dropped++
+	}
+}
+
+// Truncated returns the amount of lines that were dropped
+func (b *capBuffer) Truncated() int {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.dropped
+}
+
+// Bytes returns the lines held on to, with a line saying how many
+// were dropped in between, once whatever is left has been flushed
+func (b *capBuffer) Bytes() []byte {
+	b.lw.Flush()
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	out := make([]byte, 0, len(b.head))
+	out = append(out, b.head...)
+	if b.dropped > 0 {
+		msg := fmt.Sprintf("... %d lines truncated", b.dropped)
+		if b.logFile != "" {
+			msg += ", see " + b.logFile
+		}
+		out = append(out, msg+"\n"...)
+	}
+	for i := range b.tail {
+		out = append(out, b.tail[(b.next+i)%len(b.tail)]...)
+	}
+	return out
+}
+
+// lastLines returns a copy of the last n lines in b
+func lastLines(b []byte, n int) []byte {
+	b = bytes.TrimRight(b, "\n")
+	if len(b) == 0 {
+		return nil
+	}
+
+	pos := len(b)
+	for i := 0; i < n && pos >= 0; i++ {
+		pos = bytes.LastIndexByte(b[:pos], '\n')
+	}
+
+	lines := make([]byte, 0, len(b)-pos)
+	lines = append(lines, b[pos+1:]...)
+	return append(lines, '\n')
+}
@@ -0,0 +1,75 @@
+package vow
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLineWriter(t *testing.T) {
+	var lines []string
+	lw := newLineWriter(func(b []byte) {
+		lines = append(lines, string(b))
+	})
+
+	lw.Write([]byte("hello"))
+	assert.Empty(t, lines, "partial lines should be held on to")
+
+	lw.Write([]byte(" world\nfoo\nbar"))
+	assert.Equal(t, []string{"hello world\n", "foo\n"}, lines)
+
+	lw.Flush()
+	assert.Equal(t, []string{"hello world\n", "foo\n", "bar\n"}, lines)
+
+	lw.Flush()
+	assert.Len(t, lines, 3, "flushing an empty buffer should not write")
+}
+
+func TestLineWriter_LongLine(t *testing.T) {
+	var lines []string
+	lw := newLineWriter(func(b []byte) {
+		lines = append(lines, string(b))
+	})
+
+	lw.Write(make([]byte, maxLineLength+1))
+	assert.Len(t, lines, 1)
+}
+
+func TestLastLines(t *testing.T) {
+	tests := []struct {
+		In  string
+		N   int
+		Out string
+	}{
+		{In: "", N: 2, Out: ""},
+		{In: "a\n", N: 2, Out: "a\n"},
+		{In: "a\nb\nc\n", N: 2, Out: "b\nc\n"},
+		{In: "a\nb\nc", N: 2, Out: "b\nc\n"},
+		{In: "a\nb\nc\n\n", N: 3, Out: "a\nb\nc\n"},
+		{In: "a\nb\nc\n", N: 5, Out: "a\nb\nc\n"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.Out, string(lastLines([]byte(test.In), test.N)), "input %q", test.In)
+	}
+}
+
+func TestCapBuffer(t *testing.T) {
+	defer func(head, tail int) { outputHeadLines, outputTailLines = head, tail }(outputHeadLines, outputTailLines)
+	outputHeadLines, outputTailLines = 2, 3
+
+	b := newCapBuffer("")
+	b.Write([]byte("1\n2\n3\n"))
+	assert.Equal(t, "1\n2\n3\n", string(b.Bytes()))
+	assert.Equal(t, 0, b.Truncated())
+
+	b.Write([]byte("4\n5\n6\n7"))
+	assert.Equal(t, "1\n2\n... 2 lines truncated\n5\n6\n7\n", string(b.Bytes()))
+	assert.Equal(t, 2, b.Truncated())
+
+	b = newCapBuffer("go_test.log")
+	for i := 0; i < 10; i++ {
+		b.Write([]byte("line\n"))
+	}
+	assert.Contains(t, string(b.Bytes()), "... 5 lines truncated, see go_test.log\n")
+}
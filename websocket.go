@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// websocketGUID is added to the key of a handshake to accept it, see RFC 6455
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// the opcodes of the websocket frames snag understands
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// maxFramePayload is the largest message a client can send,
+// anything snag expects to read is much smaller than this
+const maxFramePayload = 1 << 16
+
+var errFrameTooLarge = errors.New("websocket frame is too large")
+
+// websocketAccept returns the answer to the key of a handshake
+func websocketAccept(key string) string {
+	h := sha1.New()
+	io.WriteString(h, key+websocketGUID)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// headerContains reports whether the comma separated header has the token
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// upgradeWebsocket answers the websocket handshake of r and takes over its
+// connection. The error has already been responded with when it fails.
+func upgradeWebsocket(w http.ResponseWriter, r *http.Request) (net.Conn, *bufio.ReadWriter, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != "GET" ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" ||
+		key == "" {
+		http.Error(w, "not a websocket handshake", http.StatusBadRequest)
+		return nil, nil, errors.New("not a websocket handshake")
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websockets are not supported", http.StatusInternalServerError)
+		return nil, nil, errors.New("the connection can't be taken over")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: %s\r\n\r\n", websocketAccept(key))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, rw, nil
+}
+
+// writeFrame writes the payload as a single unmasked frame,
+// the way a server sends them
+func writeFrame(w io.Writer, op byte, payload []byte) error {
+	header := []byte{0x80 | op, 0}
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xFFFF:
+		header[1] = 126
+		header = append(header, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header[1] = 127
+		header = append(header, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// frameReader reads the messages a client sends
+type frameReader struct {
+	r io.Reader
+
+	// op and message are the part of a message that
+	// was read before a control frame came in
+	op      byte
+	message []byte
+}
+
+// readFrame reads a message sent by a client. The payload is unmasked and
+// a message split over several frames is put back together, control frames
+// that come in between them are returned as soon as they are read.
+func (fr *frameReader) readFrame() (byte, []byte, error) {
+	for {
+		var header [2]byte
+		if _, err := io.ReadFull(fr.r, header[:]); err != nil {
+			return 0, nil, err
+		}
+		fin := header[0]&0x80 != 0
+		op := header[0] & 0x0F
+		masked := header[1]&0x80 != 0
+
+		n := uint64(header[1] & 0x7F)
+		switch n {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(fr.r, ext[:]); err != nil {
+				return 0, nil, err
+			}
+			n = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(fr.r, ext[:]); err != nil {
+				return 0, nil, err
+			}
+			n = binary.BigEndian.Uint64(ext[:])
+		}
+		if n > maxFramePayload || uint64(len(fr.message))+n > maxFramePayload {
+			return 0, nil, errFrameTooLarge
+		}
+
+		var mask [4]byte
+		if masked {
+			if _, err := io.ReadFull(fr.r, mask[:]); err != nil {
+				return 0, nil, err
+			}
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(fr.r, payload); err != nil {
+			return 0, nil, err
+		}
+		if masked {
+			for i := range payload {
+				payload[i] ^= mask[i%4]
+			}
+		}
+
+		if op >= opClose {
+			return op, payload, nil
+		}
+		if op != opContinuation {
+			fr.op = op
+		}
+		fr.message = append(fr.message, payload...)
+		if fin {
+			message := fr.message
+			fr.message = nil
+			return fr.op, message, nil
+		}
+	}
+}